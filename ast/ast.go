@@ -0,0 +1,258 @@
+// Package ast parses sconf files into a syntax tree of lines, keeping comments,
+// empty lines and positions, for tools that inspect or edit config files
+// without a Go type.
+//
+// Each line of a file is a Node. Lines indented deeper than a key/value or
+// list item are its children. Empty lines and comments are children of the
+// node that the next key/value or list item is a child of. Lines of a multiline
+// string, started with value "|" or "|-", are Text children of the key/value or
+// list item, regardless of their contents.
+//
+// The tree only reflects the syntax, it is not checked against the rules of
+// sconf, e.g. whether a list item can appear at a place. Printing a parsed file
+// with Fprint reproduces the input byte for byte.
+//
+// Package sconf reads config files through this tree, so the kind of each
+// line, e.g. a comment, include directive or list item, is the same for both.
+package ast
+
+import (
+	"strings"
+)
+
+// Kind is the kind of line.
+type Kind int
+
+const (
+	Blank    Kind = iota + 1 // Empty line.
+	Comment                  // Line starting with "#" after whitespace.
+	KeyValue                 // "key: value", or "key:" with the value on nested lines.
+	Item                     // List item "- value", or "-" with the value on nested lines.
+	Include                  // Include directive "include file", not looking like a key/value.
+	Text                     // Line of a multiline string, or other line.
+)
+
+var kindNames = map[Kind]string{
+	Blank:    "blank",
+	Comment:  "comment",
+	KeyValue: "keyvalue",
+	Item:     "item",
+	Include:  "include",
+	Text:     "text",
+}
+
+func (k Kind) String() string {
+	if s, ok := kindNames[k]; ok {
+		return s
+	}
+	return "invalid"
+}
+
+// Node is a line in a file, with lines indented deeper as children.
+type Node struct {
+	Kind Kind
+	Line int // 1-based line number, 0 for nodes not read from a file.
+
+	// Column is the 1-based byte offset of the first character after the indent.
+	Column int
+
+	// Indent is the leading indenting, in whole levels.
+	Indent string
+
+	// Key of a KeyValue.
+	Key string
+
+	// Value is the text after the colon of a KeyValue, after the dash of an Item,
+	// and after "include" of an Include, without the separating space. For a
+	// Comment, it is the text after "#". For Text, it is the line without Indent.
+	Value string
+
+	// ValueColumn is the 1-based byte offset of Value in the line.
+	ValueColumn int
+
+	// Raw is the full line without line ending. It is written when printing.
+	Raw string
+
+	// EOL is the line ending, "\n" or "\r\n". It is empty for a last line without
+	// newline.
+	EOL string
+
+	Children []*Node
+}
+
+// IsBlock returns whether n starts a multiline string.
+func (n *Node) IsBlock() bool {
+	return (n.Kind == KeyValue || n.Kind == Item) && (n.Value == "|" || n.Value == "|-")
+}
+
+// File is a parsed sconf file.
+type File struct {
+	Indent string  // One level of indenting, as passed to Parse.
+	Nodes  []*Node // Lines that are not indented, with their children.
+
+	// Path of the file, optional, not set by Parse. Include directives are
+	// relative to it when a changed file is checked by sconf.Set and sconf.Delete.
+	Path string
+}
+
+// Lines returns all nodes of f in order of the file.
+func (f *File) Lines() []*Node {
+	var l []*Node
+	var walk func(nodes []*Node)
+	walk = func(nodes []*Node) {
+		for _, n := range nodes {
+			l = append(l, n)
+			walk(n.Children)
+		}
+	}
+	walk(f.Nodes)
+	return l
+}
+
+func indentOrTab(indent string) string {
+	if indent == "" {
+		return "\t"
+	}
+	return indent
+}
+
+// Parse parses buf as an sconf file with indent as one level of indenting, a
+// tab if empty. Any input can be parsed.
+func Parse(buf []byte, indent string) *File {
+	indent = indentOrTab(indent)
+	f := &File{Indent: indent}
+
+	lines := splitLines(string(buf))
+	type open struct {
+		n     *Node
+		depth int
+	}
+	var stack []open    // Nodes that can get children, deepest last.
+	var pending []*Node // Empty lines and comments, for the parent of the next line.
+	add := func(n *Node, depth int) {
+		for len(stack) > 0 && stack[len(stack)-1].depth >= depth {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			f.Nodes = append(f.Nodes, pending...)
+			f.Nodes = append(f.Nodes, n)
+		} else {
+			p := stack[len(stack)-1].n
+			p.Children = append(p.Children, pending...)
+			p.Children = append(p.Children, n)
+		}
+		pending = nil
+		stack = append(stack, open{n, depth})
+	}
+
+	for i := 0; i < len(lines); i++ {
+		n, depth := parseLine(lines[i], i+1, indent)
+		if n.Kind == Blank || n.Kind == Comment {
+			pending = append(pending, n)
+			continue
+		}
+		add(n, depth)
+		if !n.IsBlock() {
+			continue
+		}
+
+		// Lines of the multiline string. Empty lines are only part of the string
+		// when followed by another line of the string.
+		prefix := n.Indent + indent
+		var empty []*Node
+		for i+1 < len(lines) {
+			l := lines[i+1]
+			if l.raw == "" {
+				empty = append(empty, &Node{Kind: Blank, Line: i + 2, Column: 1, ValueColumn: 1, EOL: l.eol})
+				i++
+				continue
+			}
+			if !strings.HasPrefix(l.raw, prefix) {
+				break
+			}
+			n.Children = append(n.Children, empty...)
+			empty = nil
+			n.Children = append(n.Children, &Node{
+				Kind:        Text,
+				Line:        i + 2,
+				Column:      len(prefix) + 1,
+				Indent:      prefix,
+				Value:       l.raw[len(prefix):],
+				ValueColumn: len(prefix) + 1,
+				Raw:         l.raw,
+				EOL:         l.eol,
+			})
+			i++
+		}
+		pending = append(pending, empty...)
+	}
+	if len(pending) > 0 {
+		f.Nodes = append(f.Nodes, pending...)
+	}
+	return f
+}
+
+type line struct {
+	raw string
+	eol string
+}
+
+func splitLines(s string) []line {
+	var l []line
+	for s != "" {
+		var ln line
+		if i := strings.IndexByte(s, '\n'); i >= 0 {
+			ln.raw, ln.eol = s[:i], "\n"
+			s = s[i+1:]
+		} else {
+			ln.raw = s
+			s = ""
+		}
+		if ln.eol != "" && strings.HasSuffix(ln.raw, "\r") {
+			ln.raw, ln.eol = ln.raw[:len(ln.raw)-1], "\r\n"
+		}
+		l = append(l, ln)
+	}
+	return l
+}
+
+// parseLine returns the node for a line, and its depth.
+func parseLine(l line, linenumber int, indent string) (*Node, int) {
+	n := &Node{Line: linenumber, Raw: l.raw, EOL: l.eol}
+	s := l.raw
+	depth := 0
+	for strings.HasPrefix(s, indent) {
+		s = s[len(indent):]
+		depth++
+	}
+	n.Indent = l.raw[:len(l.raw)-len(s)]
+	n.Column = len(n.Indent) + 1
+	value := func(v string) {
+		n.Value = v
+		n.ValueColumn = len(l.raw) - len(v) + 1
+	}
+
+	switch {
+	case l.raw == "":
+		n.Kind = Blank
+		value("")
+	case strings.HasPrefix(strings.TrimSpace(s), "#"):
+		n.Kind = Comment
+		value(s[strings.Index(s, "#")+1:])
+	case strings.HasPrefix(s, "include ") && !strings.Contains(s, ": ") && !strings.HasSuffix(s, ":"):
+		n.Kind = Include
+		value(s[len("include "):])
+	case s == "-" || strings.HasPrefix(s, "- "):
+		n.Kind = Item
+		value(strings.TrimPrefix(s[1:], " "))
+	case strings.Contains(s, ":"):
+		t := strings.SplitN(s, ":", 2)
+		n.Kind = KeyValue
+		n.Key = t[0]
+		value(strings.TrimPrefix(t[1], " "))
+	default:
+		n.Kind = Text
+		value(s)
+	}
+	return n, depth
+}
@@ -0,0 +1,113 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRoundtrip(t *testing.T) {
+	test := func(name string, buf []byte) {
+		t.Helper()
+		f := Parse(buf, "")
+		if out := f.Bytes(); !bytes.Equal(out, buf) {
+			t.Fatalf("%s: got:\n%q\n\nexpected:\n%q", name, out, buf)
+		}
+		// Each line is a node.
+		if n, exp := len(f.Lines()), strings.Count(string(buf), "\n"); n != exp && n != exp+1 {
+			t.Fatalf("%s: got %d nodes, expected %d lines", name, n, exp)
+		}
+	}
+
+	err := filepath.Walk("../testdata", func(name string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		buf, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		test(name, buf)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+
+	test("empty", []byte(""))
+	test("no newline", []byte("Key: value"))
+	test("crlf", []byte("Key: value\r\nList:\r\n\t- a\r\n"))
+	test("whitespace", []byte("Key:  value \n\t\n  \n"))
+	test("block", []byte("Text: |\n\tline\n\n\t# not a comment\n\n\n# comment\nKey: x\n"))
+	test("garbage", []byte("\t\t\tdeep\n:\n-\n- \n-x\n#\n"))
+}
+
+func TestTree(t *testing.T) {
+	const src = `# header
+
+Name: x
+List:
+	- a
+	-
+		# nested comment
+		Key: v
+Text: |-
+	line 1
+
+	line 2
+
+include other.conf
+`
+	f := Parse([]byte(src), "")
+
+	var b strings.Builder
+	var dump func(nodes []*Node, depth int)
+	dump = func(nodes []*Node, depth int) {
+		for _, n := range nodes {
+			fmt.Fprintf(&b, "%s%d:%d %s key=%q value=%q@%d\n", strings.Repeat("  ", depth), n.Line, n.Column, n.Kind, n.Key, n.Value, n.ValueColumn)
+			dump(n.Children, depth+1)
+		}
+	}
+	dump(f.Nodes, 0)
+	exp := `1:1 comment key="" value=" header"@2
+2:1 blank key="" value=""@1
+3:1 keyvalue key="Name" value="x"@7
+4:1 keyvalue key="List" value=""@6
+  5:2 item key="" value="a"@4
+  6:2 item key="" value=""@3
+    7:3 comment key="" value=" nested comment"@4
+    8:3 keyvalue key="Key" value="v"@8
+9:1 keyvalue key="Text" value="|-"@7
+  10:2 text key="" value="line 1"@2
+  11:1 blank key="" value=""@1
+  12:2 text key="" value="line 2"@2
+13:1 blank key="" value=""@1
+14:1 include key="" value="other.conf"@9
+`
+	if b.String() != exp {
+		t.Fatalf("got:\n%s\nexpected:\n%s", b.String(), exp)
+	}
+}
+
+func TestIndent(t *testing.T) {
+	const src = "A:\n  B:\n    - x\n  C: y\n"
+	f := Parse([]byte(src), "  ")
+	if len(f.Nodes) != 1 || len(f.Nodes[0].Children) != 2 || len(f.Nodes[0].Children[0].Children) != 1 {
+		t.Fatalf("unexpected tree for space indent")
+	}
+	if n := f.Nodes[0].Children[0].Children[0]; n.Kind != Item || n.Indent != "    " || n.Value != "x" {
+		t.Fatalf("got %#v, expected item", n)
+	}
+}
+
+func TestIncludeKey(t *testing.T) {
+	f := Parse([]byte("include a.conf\ninclude x: y\ninclude z:\n"), "")
+	for i, exp := range []Kind{Include, KeyValue, KeyValue} {
+		if n := f.Nodes[i]; n.Kind != exp {
+			t.Fatalf("line %d: got %s, expected %s", n.Line, n.Kind, exp)
+		}
+	}
+}
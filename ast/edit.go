@@ -0,0 +1,259 @@
+package ast
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Step is an element of a path: a key of a struct or map, or an index in a
+// list.
+type Step struct {
+	Key   string
+	Index int // For a list index, with empty Key.
+}
+
+// ParsePath parses a path to a value, for Get, Set and Delete. A path is a
+// sequence of keys separated by dots, each optionally followed by list indices
+// in brackets, e.g. "Mail.SMTP.Host", "Accounts.bob" or "Listeners.public.IPs[0]".
+// Keys with a dot, bracket, quote or whitespace are written as a Go string, e.g.
+// `Domains."example.org"`.
+func ParsePath(path string) ([]Step, error) {
+	var l []Step
+	s := path
+	for {
+		var key string
+		if strings.HasPrefix(s, `"`) {
+			q, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("bad quoted key in path %q", path)
+			}
+			key, _ = strconv.Unquote(q)
+			s = s[len(q):]
+		} else {
+			i := strings.IndexAny(s, ".[")
+			if i < 0 {
+				i = len(s)
+			}
+			key = s[:i]
+			s = s[i:]
+			if key == "" || strings.TrimSpace(key) != key || strings.ContainsAny(key, `]"`) {
+				return nil, fmt.Errorf("bad key %q in path %q", key, path)
+			}
+		}
+		l = append(l, Step{Key: key})
+
+		for strings.HasPrefix(s, "[") {
+			i := strings.Index(s, "]")
+			if i < 0 {
+				return nil, fmt.Errorf("missing ] in path %q", path)
+			}
+			index, err := strconv.ParseUint(s[1:i], 10, 31)
+			if err != nil {
+				return nil, fmt.Errorf("bad index %q in path %q", s[1:i], path)
+			}
+			l = append(l, Step{Index: int(index)})
+			s = s[i+1:]
+		}
+
+		if s == "" {
+			return l, nil
+		}
+		if !strings.HasPrefix(s, ".") {
+			return nil, fmt.Errorf("bad path %q, expected dot after key", path)
+		}
+		s = s[1:]
+	}
+}
+
+func (s Step) String() string {
+	if s.Key == "" {
+		return fmt.Sprintf("[%d]", s.Index)
+	}
+	if strings.ContainsAny(s.Key, ".[]\" \t") {
+		return strconv.Quote(s.Key)
+	}
+	return s.Key
+}
+
+// pathString returns the path of steps, for errors.
+func pathString(steps []Step) string {
+	var s string
+	for i, st := range steps {
+		if i > 0 && st.Key != "" {
+			s += "."
+		}
+		s += st.String()
+	}
+	return s
+}
+
+// find returns the index in nodes of the key/value or list item for step, or -1.
+// Include directives are not followed.
+func find(nodes []*Node, step Step) int {
+	var item int
+	for i, n := range nodes {
+		if step.Key != "" && n.Kind == KeyValue && n.Key == step.Key {
+			return i
+		} else if step.Key == "" && n.Kind == Item {
+			if item == step.Index {
+				return i
+			}
+			item++
+		}
+	}
+	return -1
+}
+
+// Get returns the key/value or list item at path.
+func (f *File) Get(path string) (*Node, error) {
+	steps, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	nodes := f.Nodes
+	var n *Node
+	for i, step := range steps {
+		j := find(nodes, step)
+		if j < 0 {
+			return nil, fmt.Errorf("%s: not found", pathString(steps[:i+1]))
+		}
+		n = nodes[j]
+		nodes = n.Children
+	}
+	return n, nil
+}
+
+// Set sets the value at path. Missing keys on the path are added after the last
+// key or list item of their parent. A list index may be one past the last item,
+// adding an item.
+//
+// Value is the text after the key or dash, without separating space, e.g.
+// "smtp2.example.org". Lines after the first line of value are nested lines
+// and must be indented one level or more. They replace the existing nested
+// lines, e.g. "\n\t- a\n\t- b" sets a list of two items.
+func (f *File) Set(path, value string) error {
+	steps, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(value, "\n")
+	for _, s := range lines[1:] {
+		if s != "" && !strings.HasPrefix(s, f.Indent) {
+			return fmt.Errorf("nested line %q of value not indented", s)
+		}
+	}
+
+	nodes := &f.Nodes
+	var indent string
+	var n *Node
+	for i, step := range steps {
+		j := find(*nodes, step)
+		if j < 0 {
+			if step.Key == "" && count(*nodes, KeyValue) > 0 || step.Key != "" && count(*nodes, Item) > 0 {
+				return fmt.Errorf("%s: cannot mix keys and list items", pathString(steps[:i+1]))
+			} else if step.Key == "" && step.Index != count(*nodes, Item) {
+				return fmt.Errorf("%s: index out of range", pathString(steps[:i+1]))
+			}
+			n = &Node{Kind: KeyValue, Key: step.Key, Indent: indent, EOL: f.eol()}
+			if step.Key == "" {
+				n.Kind = Item
+			}
+			n.setValue("")
+			*nodes, j = insert(*nodes, n)
+		}
+		n = (*nodes)[j]
+		if n.Value != "" && i < len(steps)-1 {
+			return fmt.Errorf("%s: has value %q, not nested lines", pathString(steps[:i+1]), n.Value)
+		}
+		nodes = &n.Children
+		indent = n.Indent + f.Indent
+	}
+
+	n.setValue(lines[0])
+	var children []*Node
+	if len(lines) > 1 {
+		for i, s := range lines[1:] {
+			if s != "" {
+				lines[1+i] = n.Indent + s
+			}
+		}
+		nf := Parse([]byte(strings.Join(lines[1:], "\n")), f.Indent)
+		for _, c := range nf.Lines() {
+			c.Line = 0
+			c.EOL = f.eol()
+		}
+		children = nf.Nodes
+	}
+	n.Children = children
+	return nil
+}
+
+// Delete removes the key/value or list item at path, with its nested lines.
+func (f *File) Delete(path string) error {
+	steps, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	nodes := &f.Nodes
+	for i, step := range steps {
+		j := find(*nodes, step)
+		if j < 0 {
+			return fmt.Errorf("%s: not found", pathString(steps[:i+1]))
+		}
+		if i == len(steps)-1 {
+			*nodes = append((*nodes)[:j:j], (*nodes)[j+1:]...)
+			return nil
+		}
+		nodes = &(*nodes)[j].Children
+	}
+	panic("unreachable")
+}
+
+// setValue sets the value of a key/value or list item, and its Raw line.
+func (n *Node) setValue(value string) {
+	raw := n.Indent
+	if n.Kind == KeyValue {
+		raw += n.Key + ":"
+	} else {
+		raw += "-"
+	}
+	n.Column = len(n.Indent) + 1
+	n.ValueColumn = len(raw) + 1
+	if value != "" {
+		raw += " "
+		n.ValueColumn++
+	}
+	n.Value = value
+	n.Raw = raw + value
+}
+
+// insert adds n to nodes after the last key/value or list item, before trailing
+// comments and empty lines, and returns the new nodes and the index of n.
+func insert(nodes []*Node, n *Node) ([]*Node, int) {
+	i := len(nodes)
+	for i > 0 && (nodes[i-1].Kind == Blank || nodes[i-1].Kind == Comment) {
+		i--
+	}
+	l := append([]*Node{}, nodes[:i]...)
+	l = append(l, n)
+	return append(l, nodes[i:]...), i
+}
+
+func count(nodes []*Node, kind Kind) int {
+	var n int
+	for _, c := range nodes {
+		if c.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// eol returns the line ending for new lines, that of the first line of f.
+func (f *File) eol() string {
+	if l := f.Lines(); len(l) > 0 && l[0].EOL == "\r\n" {
+		return "\r\n"
+	}
+	return "\n"
+}
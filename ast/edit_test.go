@@ -0,0 +1,136 @@
+package ast
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	test := func(path string, exp []Step) {
+		t.Helper()
+		l, err := ParsePath(path)
+		if err != nil {
+			t.Fatalf("parse path %q: %v", path, err)
+		}
+		if !reflect.DeepEqual(l, exp) {
+			t.Fatalf("parse path %q: got %v, expected %v", path, l, exp)
+		}
+		if s := pathString(l); s != path {
+			t.Fatalf("path string: got %q, expected %q", s, path)
+		}
+	}
+	test("Mail.SMTP.Host", []Step{{Key: "Mail"}, {Key: "SMTP"}, {Key: "Host"}})
+	test("Admins[1]", []Step{{Key: "Admins"}, {Index: 1}})
+	test("Matrix[0][2].Name", []Step{{Key: "Matrix"}, {Index: 0}, {Index: 2}, {Key: "Name"}})
+	test(`Domains."example.org".Admin`, []Step{{Key: "Domains"}, {Key: "example.org"}, {Key: "Admin"}})
+
+	testBad := func(path string) {
+		t.Helper()
+		if _, err := ParsePath(path); err == nil {
+			t.Fatalf("parse path %q: expected error", path)
+		}
+	}
+	testBad("")
+	testBad("A.")
+	testBad(".A")
+	testBad("A..B")
+	testBad("A[x]")
+	testBad("A[-1]")
+	testBad("A[1")
+	testBad("A[0]B")
+	testBad(`"unterminated`)
+	testBad(" A")
+	testBad("[0]")
+}
+
+func TestEdit(t *testing.T) {
+	const src = `# Config.
+Mail:
+	SMTP:
+		# The relay.
+		Host: smtp1
+Admins:
+	- alice
+	- bob
+Accounts:
+	bob:
+		Domain: example.org
+
+# End.
+`
+	f := Parse([]byte(src), "")
+
+	n, err := f.Get("Mail.SMTP.Host")
+	if err != nil || n.Value != "smtp1" || n.Line != 5 {
+		t.Fatalf("get: got %v %v, expected line 5 with value smtp1", n, err)
+	}
+	if n, err := f.Get("Admins[1]"); err != nil || n.Value != "bob" {
+		t.Fatalf("get item: got %v %v, expected bob", n, err)
+	}
+	if _, err := f.Get("Admins[2]"); err == nil || err.Error() != "Admins[2]: not found" {
+		t.Fatalf("get missing: got %v, expected not found", err)
+	}
+	if _, err := f.Get("Mail.IMAP.Host"); err == nil || err.Error() != "Mail.IMAP: not found" {
+		t.Fatalf("get missing: got %v, expected not found", err)
+	}
+
+	set := func(path, value string) {
+		t.Helper()
+		if err := f.Set(path, value); err != nil {
+			t.Fatalf("set %s: %v", path, err)
+		}
+	}
+	set("Mail.SMTP.Host", "smtp2")
+	set("Mail.SMTP.Port", "587")
+	set("Mail.IMAP.Host", "imap")
+	set("Admins[0]", "carol")
+	set("Admins[2]", "dave")
+	set("Accounts.bob", "\n\tDomain: example.com\n\tQuota: 1GB")
+	set("Limits", "\n\t- 1\n\t- 2")
+	if err := f.Delete("Admins[1]"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := f.Delete("Limits[0]"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	exp := `# Config.
+Mail:
+	SMTP:
+		# The relay.
+		Host: smtp2
+		Port: 587
+	IMAP:
+		Host: imap
+Admins:
+	- carol
+	- dave
+Accounts:
+	bob:
+		Domain: example.com
+		Quota: 1GB
+Limits:
+	- 2
+
+# End.
+`
+	if s := string(f.Bytes()); s != exp {
+		t.Fatalf("got:\n%s\nexpected:\n%s", s, exp)
+	}
+
+	testBad := func(err error, exp string) {
+		t.Helper()
+		if err == nil || err.Error() != exp {
+			t.Fatalf("got error %v, expected %q", err, exp)
+		}
+	}
+	testBad(f.Set("Admins[3]", "x"), "Admins[3]: index out of range")
+	testBad(f.Set("Admins.x", "x"), "Admins.x: cannot mix keys and list items")
+	testBad(f.Set("Mail[0]", "x"), "Mail[0]: cannot mix keys and list items")
+	testBad(f.Set("Mail.SMTP.Host.x", "x"), `Mail.SMTP.Host: has value "smtp2", not nested lines`)
+	testBad(f.Set("Mail", "\nHost: x"), `nested line "Host: x" of value not indented`)
+	testBad(f.Delete("Accounts.alice"), "Accounts.alice: not found")
+	if s := string(f.Bytes()); s != exp {
+		t.Fatalf("changed after errors:\n%s", s)
+	}
+}
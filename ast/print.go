@@ -0,0 +1,31 @@
+package ast
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// Fprint writes the lines of f to w. Each line is written as its Raw text and
+// EOL. Lines without EOL other than the last line of the file, e.g. of nodes
+// added by a tool, get a newline.
+func Fprint(w io.Writer, f *File) error {
+	out := bufio.NewWriter(w)
+	lines := f.Lines()
+	for i, n := range lines {
+		out.WriteString(n.Raw)
+		eol := n.EOL
+		if eol == "" && i < len(lines)-1 {
+			eol = "\n"
+		}
+		out.WriteString(eol)
+	}
+	return out.Flush()
+}
+
+// Bytes returns the printed file.
+func (f *File) Bytes() []byte {
+	var b bytes.Buffer
+	Fprint(&b, f)
+	return b.Bytes()
+}
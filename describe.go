@@ -2,11 +2,18 @@ package sconf
 
 import (
 	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/mjl-/xfmt"
 )
@@ -16,11 +23,54 @@ var errNoElem = errors.New("no elements")
 type writeError struct{ error }
 
 type writer struct {
-	out      *bufio.Writer
-	wrote    int
-	prefix   string
-	keepZero bool // If set, we also write zero values.
-	docs     bool // If set, we write comments.
+	out         *bufio.Writer
+	wrote       int
+	prefix      string
+	indentUnit  string // One level of indenting.
+	keepZero    bool   // If set, we also write zero values.
+	example     bool   // For Describe, deprecated fields are left out.
+	docs        bool   // If set, we write comments.
+	maxWidth    int    // For wrapping comments, no wrapping if negative.
+	floatFormat string // Format for fmt.Sprintf.
+	base        int    // Base for integers of the current field, from its sconf tag.
+}
+
+func newWriter(w io.Writer, opts EncoderOptions) *writer {
+	wr := &writer{
+		out:         bufio.NewWriter(w),
+		indentUnit:  opts.Indent,
+		keepZero:    opts.KeepZero,
+		docs:        opts.Docs,
+		maxWidth:    opts.MaxWidth,
+		floatFormat: opts.FloatFormat,
+	}
+	if wr.indentUnit == "" {
+		wr.indentUnit = "\t"
+	}
+	if wr.maxWidth == 0 {
+		wr.maxWidth = 80
+	}
+	if wr.floatFormat == "" {
+		wr.floatFormat = "%f"
+	}
+	return wr
+}
+
+// run calls fn and returns the error it raised.
+func (w *writer) run(fn func()) (err error) {
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if e, ok := x.(writeError); ok {
+			err = error(e)
+		} else {
+			panic(x)
+		}
+	}()
+	fn()
+	return nil
 }
 
 func (w *writer) error(err error) {
@@ -45,21 +95,40 @@ func (w *writer) flush() {
 }
 
 func (w *writer) indent() {
-	w.prefix += "\t"
+	w.prefix += w.indentUnit
 }
 
 func (w *writer) unindent() {
-	w.prefix = w.prefix[:len(w.prefix)-1]
+	w.prefix = w.prefix[:len(w.prefix)-len(w.indentUnit)]
 }
 
+// isOptional returns whether a field is optional. Deprecated fields are always optional.
 func isOptional(sconfTag string) bool {
-	return hasTagWord(sconfTag, "optional")
+	return hasTagWord(sconfTag, "optional") || isDeprecated(sconfTag)
+}
+
+func isDeprecated(sconfTag string) bool {
+	return hasTagWord(sconfTag, "deprecated")
 }
 
 func isIgnore(sconfTag string) bool {
 	return hasTagWord(sconfTag, "-") || hasTagWord(sconfTag, "ignore")
 }
 
+// tagBase returns the base for writing integers from an sconf tag with word
+// "binary", "octal" or "hex", and 10 otherwise.
+func tagBase(sconfTag string) int {
+	switch {
+	case hasTagWord(sconfTag, "binary"):
+		return 2
+	case hasTagWord(sconfTag, "octal"):
+		return 8
+	case hasTagWord(sconfTag, "hex"):
+		return 16
+	}
+	return 10
+}
+
 func hasTagWord(sconfTag, word string) bool {
 	l := strings.Split(sconfTag, ",")
 	for _, s := range l {
@@ -70,53 +139,164 @@ func hasTagWord(sconfTag, word string) bool {
 	return false
 }
 
-func (w *writer) describeMap(v reflect.Value) {
+// mapEntry is a key of a map with its text.
+type mapEntry struct {
+	k reflect.Value
+	s string
+}
+
+// mapKeys returns the keys of map v in the order they are written.
+func (w *writer) mapKeys(v reflect.Value) []mapEntry {
 	t := v.Type()
-	if t.Key().Kind() != reflect.String {
-		w.error(fmt.Errorf("map key must be string"))
+	var l []mapEntry
+	for _, k := range v.MapKeys() {
+		s, err := mapKeyText(k)
+		w.check(err)
+		l = append(l, mapEntry{k, s})
 	}
-	keys := v.MapKeys()
-	sort.Slice(keys, func(i, j int) bool {
-		return keys[i].String() < keys[j].String()
-	})
-	have := false
-	for _, k := range keys {
-		have = true
-		w.write(w.prefix)
-		w.write(k.String() + ":")
-		mv := v.MapIndex(k)
-		if !w.keepZero && mv.Kind() == reflect.Struct && isEmptyStruct(mv) {
-			w.write(" nil\n")
-			continue
+	// Keys with a "Compare(T) int" method, like netip.Addr, are sorted with it.
+	compare, ok := t.Key().MethodByName("Compare")
+	if ok && (compare.Type.NumIn() != 2 || compare.Type.In(1) != t.Key() || compare.Type.NumOut() != 1 || compare.Type.Out(0).Kind() != reflect.Int) {
+		ok = false
+	}
+	sort.Slice(l, func(i, j int) bool {
+		a, b := l[i].k, l[j].k
+		if ok {
+			return compare.Func.Call([]reflect.Value{a, b})[0].Int() < 0
 		}
-		w.describeValue(mv)
+		if t.Key() == durationType || !isText(t.Key()) {
+			switch a.Kind() {
+			case reflect.Bool:
+				return !a.Bool() && b.Bool()
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				return a.Int() < b.Int()
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				return a.Uint() < b.Uint()
+			case reflect.Float32, reflect.Float64:
+				return a.Float() < b.Float()
+			}
+		}
+		return l[i].s < l[j].s
+	})
+	return l
+}
+
+func (w *writer) describeMap(v reflect.Value) {
+	t := v.Type()
+	l := w.mapKeys(v)
+	for _, e := range l {
+		w.describeEntry(e.s, v.MapIndex(e.k))
 	}
-	if have {
+	if len(l) > 0 {
 		return
 	}
+	k := "x"
+	if t.Key().Kind() != reflect.String {
+		s, err := mapKeyText(reflect.Zero(t.Key()))
+		w.check(err)
+		if s != "" {
+			k = s
+		}
+	}
 	w.write(w.prefix)
-	w.write("x:")
+	w.write(k + ":")
 	w.describeValue(reflect.Zero(t.Elem()))
 }
 
+// describeEntry writes the map entry with key text k and value mv.
+func (w *writer) describeEntry(k string, mv reflect.Value) {
+	w.write(w.prefix)
+	w.write(k + ":")
+	if !w.keepZero && mv.Kind() == reflect.Struct && !isText(mv.Type()) && !isMarshaler(mv.Type()) && !isStdType(mv.Type()) && isEmptyStruct(mv) {
+		w.write(" nil\n")
+		return
+	}
+	// Render first: a value written as "nil" would be parsed as special value for
+	// the zero value.
+	var b bytes.Buffer
+	vw := *w
+	vw.out = bufio.NewWriter(&b)
+	vw.describeValue(mv)
+	vw.flush()
+	if b.String() != " nil\n" {
+		w.write(b.String())
+		return
+	}
+	t := mv.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == durationType || isStdType(t) || isMarshaler(t) || t.Kind() != reflect.String && !isText(t) {
+		w.error(fmt.Errorf("map value of type %v written as nil cannot be parsed back", mv.Type()))
+	}
+	w.write(" " + strconv.Quote("nil") + "\n")
+}
+
+// mapKeyText returns the text for map key k, as parsed by parseMapKey.
+func mapKeyText(k reflect.Value) (string, error) {
+	t := k.Type()
+	var s string
+	if t == durationType {
+		s = time.Duration(k.Int()).String()
+	} else if isText(t) {
+		// MarshalText may have a pointer receiver, so call it on an addressable copy.
+		pv := reflect.New(t)
+		pv.Elem().Set(k)
+		buf, err := pv.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return "", err
+		}
+		s = string(buf)
+	} else {
+		switch t.Kind() {
+		case reflect.String:
+			s = k.String()
+		case reflect.Bool:
+			s = strconv.FormatBool(k.Bool())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			s = strconv.FormatInt(k.Int(), 10)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			s = strconv.FormatUint(k.Uint(), 10)
+		case reflect.Float32, reflect.Float64:
+			s = strconv.FormatFloat(k.Float(), 'g', -1, t.Bits())
+		default:
+			return "", fmt.Errorf("unsupported map key type %v", t)
+		}
+	}
+	// Keys starting with "include " would look like an include directive, and
+	// keys starting with "- " like a list item.
+	if s == "" || strings.ContainsAny(s, ":\n") || strings.TrimLeft(s, " \t") != s || strings.HasPrefix(s, "#") || strings.HasPrefix(s, "include ") || s == "-" || strings.HasPrefix(s, "- ") {
+		return "", fmt.Errorf("map key %q cannot be written", s)
+	}
+	return s, nil
+}
+
+// whether values of non-pointer type t are written with MarshalText.
+func isText(t reflect.Type) bool {
+	return t.Kind() != reflect.Ptr && t.Kind() != reflect.Interface && reflect.PtrTo(t).Implements(textMarshalerType)
+}
+
+// whether values of non-pointer type t are written with MarshalSconf.
+func isMarshaler(t reflect.Type) bool {
+	return t.Kind() != reflect.Ptr && t.Kind() != reflect.Interface && reflect.PtrTo(t).Implements(marshalerType)
+}
+
 // whether v is a zero value of a struct type with all fields optional or
 // ignored, causing it to write nothing when using Write.
 func isEmptyStruct(v reflect.Value) bool {
 	if v.Kind() != reflect.Struct {
 		panic("not a struct")
 	}
-	t := v.Type()
-	n := t.NumField()
-	for i := 0; i < n; i++ {
-		ft := t.Field(i)
-		tag := ft.Tag.Get("sconf")
-		if !ft.IsExported() || isIgnore(tag) {
-			continue
-		}
-		if !isOptional(tag) {
+	fields, err := structFields(v.Type())
+	if err != nil {
+		// Written as struct, causing the error to be returned.
+		return false
+	}
+	for _, f := range fields {
+		if !isOptional(f.Tag.Get("sconf")) {
 			return false
 		}
-		if !isZeroIgnored(v.Field(i)) {
+		if !isZeroIgnored(fieldValue(v, f, false)) {
 			return false
 		}
 	}
@@ -125,21 +305,21 @@ func isEmptyStruct(v reflect.Value) bool {
 
 // whether v is zero, taking ignored values into account.
 func isZeroIgnored(v reflect.Value) bool {
+	if isText(v.Type()) || isMarshaler(v.Type()) || isStdType(v.Type()) {
+		return v.IsZero()
+	}
 	switch v.Kind() {
 	case reflect.Slice, reflect.Map:
 		return v.Len() == 0
 	case reflect.Ptr:
 		return v.IsZero() || isZeroIgnored(v.Elem())
 	case reflect.Struct:
-		t := v.Type()
-		n := t.NumField()
-		for i := 0; i < n; i++ {
-			ft := t.Field(i)
-			tag := ft.Tag.Get("sconf")
-			if !ft.IsExported() || isIgnore(tag) {
-				continue
-			}
-			if !isZeroIgnored(v.Field(i)) {
+		fields, err := structFields(v.Type())
+		if err != nil {
+			return false
+		}
+		for _, f := range fields {
+			if !isZeroIgnored(fieldValue(v, f, false)) {
 				return false
 			}
 		}
@@ -150,73 +330,135 @@ func isZeroIgnored(v reflect.Value) bool {
 }
 
 func (w *writer) describeStruct(v reflect.Value) {
-	t := v.Type()
-	n := t.NumField()
-	for i := 0; i < n; i++ {
-		f := t.Field(i)
-		fv := v.Field(i)
-		if !f.IsExported() || isIgnore(f.Tag.Get("sconf")) {
-			continue
+	fields, err := structFields(v.Type())
+	w.check(err)
+	for _, f := range fields {
+		w.describeField(f, fieldValue(v, f, false))
+	}
+}
+
+// omitField returns whether field f with value fv is left out when writing.
+func (w *writer) omitField(f field, fv reflect.Value) bool {
+	// Deprecated fields are not mentioned in examples.
+	if w.example && isDeprecated(f.Tag.Get("sconf")) {
+		return true
+	}
+	if w.keepZero {
+		return false
+	}
+	def, hasDefault, err := fieldDefault(f.StructField)
+	w.check(err)
+	// A zero value is left out, unless parsing would set a different default.
+	return isOptional(f.Tag.Get("sconf")) && isZeroIgnored(fv) && (!hasDefault || isZeroIgnored(def))
+}
+
+// describeField writes field f with value fv, with its documentation if enabled.
+func (w *writer) describeField(f field, fv reflect.Value) {
+	if w.omitField(f, fv) {
+		return
+	}
+	if w.docs {
+		_, hasDefault, err := fieldDefault(f.StructField)
+		w.check(err)
+		doc := f.Tag.Get("sconf-doc")
+		var notes []string
+		if isDeprecated(f.Tag.Get("sconf")) {
+			note := "deprecated"
+			if msg := f.Tag.Get("sconf-deprecated"); msg != "" {
+				note += ": " + msg
+			}
+			notes = append(notes, note)
+		} else if isOptional(f.Tag.Get("sconf")) {
+			notes = append(notes, "optional")
 		}
-		if !w.keepZero && isOptional(f.Tag.Get("sconf")) && isZeroIgnored(fv) {
-			continue
+		if hasDefault {
+			notes = append(notes, "default "+f.Tag.Get("sconf-default"))
 		}
-		if w.docs {
-			doc := f.Tag.Get("sconf-doc")
-			optional := isOptional(f.Tag.Get("sconf"))
-			if doc != "" || optional {
-				s := "\n"
-				if w.wrote == 0 {
-					// No empty line at start of file.
-					s = ""
+		constraints, err := fieldConstraints(f.StructField)
+		w.check(err)
+		for _, c := range constraints {
+			notes = append(notes, c.String())
+		}
+		if doc != "" || len(notes) > 0 {
+			s := "\n"
+			if w.wrote == 0 {
+				// No empty line at start of file.
+				s = ""
+			}
+			// Treat two blank lines as section separator: the comments are not joined with
+			// lines with just "#", but instead with empty lines. To allow a hack where the
+			// first field of a config struct gives some context about the file.
+			sections := strings.Split(doc, "\n\n\n")
+			for si, section := range sections {
+				if si > 0 {
+					s += "\n\n\n"
 				}
-				// Treat two blank lines as section separator: the comments are not joined with
-				// lines with just "#", but instead with empty lines. To allow a hack where the
-				// first field of a config struct gives some context about the file.
-				sections := strings.Split(doc, "\n\n\n")
-				for si, section := range sections {
-					if si > 0 {
-						s += "\n\n\n"
+				for i, line := range strings.Split(section, "\n") {
+					if i > 0 {
+						s += "\n"
 					}
-					for i, line := range strings.Split(section, "\n") {
-						if i > 0 {
-							s += "\n"
-						}
-						s += w.prefix + "#"
-						if line != "" {
-							s += " " + line
-						}
+					s += w.prefix + "#"
+					if line != "" {
+						s += " " + line
 					}
 				}
-				if optional {
-					opt := "(optional)"
-					if !strings.HasSuffix(doc, " ") {
-						s += " "
-					}
-					s += opt
+			}
+			if len(notes) > 0 {
+				if !strings.HasSuffix(doc, " ") {
+					s += " "
 				}
-				s += "\n"
+				s += "(" + strings.Join(notes, ", ") + ")"
+			}
+			s += "\n"
+			if w.maxWidth > 0 {
 				b := &strings.Builder{}
-				err := xfmt.Format(b, strings.NewReader(s), xfmt.Config{MaxWidth: 80})
+				err := xfmt.Format(b, strings.NewReader(s), xfmt.Config{MaxWidth: w.maxWidth})
 				w.check(err)
-				w.write(b.String())
+				s = b.String()
 			}
+			w.write(s)
 		}
-		w.write(w.prefix)
-		w.write(f.Name + ":")
-		w.describeValue(fv)
 	}
+	w.write(w.prefix)
+	w.write(f.key + ":")
+	base := w.base
+	w.base = tagBase(f.Tag.Get("sconf"))
+	w.describeValue(fv)
+	w.base = base
 }
 
 func (w *writer) describeValue(v reflect.Value) {
 	t := v.Type()
 	i := v.Interface()
 
-	if t == durationType {
+	if t == byteSizeType && v.Int() < 0 {
+		w.error(fmt.Errorf("cannot write negative size %s", i))
+	}
+	if t == durationType || t == byteSizeType || t == percentType {
 		w.write(fmt.Sprintf(" %s\n", i))
 		return
 	}
 
+	if isStdType(t) {
+		w.describeStdType(v)
+		return
+	}
+
+	if isMarshaler(t) {
+		w.describeMarshaler(v)
+		return
+	}
+
+	if isText(t) {
+		// MarshalText may have a pointer receiver, so call it on an addressable copy.
+		pv := reflect.New(t)
+		pv.Elem().Set(v)
+		buf, err := pv.Interface().(encoding.TextMarshaler).MarshalText()
+		w.check(err)
+		w.describeString(string(buf))
+		return
+	}
+
 	switch t.Kind() {
 	default:
 		w.error(fmt.Errorf("unsupported value %v", t.Kind()))
@@ -226,18 +468,31 @@ func (w *writer) describeValue(v reflect.Value) {
 		w.write(fmt.Sprintf(" %v\n", i))
 
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		w.write(fmt.Sprintf(" %d\n", i))
+		switch w.base {
+		case 2:
+			w.write(fmt.Sprintf(" %#b\n", i))
+		case 8:
+			w.write(fmt.Sprintf(" %O\n", i))
+		case 16:
+			w.write(fmt.Sprintf(" %#x\n", i))
+		default:
+			w.write(fmt.Sprintf(" %d\n", i))
+		}
 
 	case reflect.Float32, reflect.Float64:
-		w.write(fmt.Sprintf(" %f\n", i))
+		w.write(" " + fmt.Sprintf(w.floatFormat, i) + "\n")
 
 	case reflect.String:
-		if strings.Contains(v.String(), "\n") {
-			w.error(fmt.Errorf("unsupported multiline string"))
+		w.describeString(v.String())
+
+	case reflect.Slice, reflect.Array:
+		// Bytes are written as base64, as parsed.
+		if t.Elem().Kind() == reflect.Uint8 {
+			buf := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(buf), v)
+			w.write(" " + base64.StdEncoding.EncodeToString(buf) + "\n")
+			return
 		}
-		w.write(fmt.Sprintf(" %s\n", i))
-
-	case reflect.Slice:
 		w.write("\n")
 		w.indent()
 		w.describeSlice(v)
@@ -258,6 +513,13 @@ func (w *writer) describeValue(v reflect.Value) {
 		w.describeStruct(v)
 		w.unindent()
 
+	case reflect.Interface:
+		if v.IsNil() {
+			w.write(" nil\n")
+		} else {
+			w.describeInterface(v.Elem())
+		}
+
 	case reflect.Map:
 		w.write("\n")
 		w.indent()
@@ -266,6 +528,60 @@ func (w *writer) describeValue(v reflect.Value) {
 	}
 }
 
+// describeString writes s, as multiline string if it contains a newline, or as
+// quoted string if it would not be parsed back as the same value otherwise.
+func (w *writer) describeString(s string) {
+	if needsQuote(s) {
+		w.write(" " + strconv.Quote(s) + "\n")
+		return
+	}
+	if !strings.Contains(s, "\n") {
+		w.write(" " + s + "\n")
+		return
+	}
+
+	marker := "|-"
+	if strings.HasSuffix(s, "\n") {
+		marker = "|"
+		s = s[:len(s)-1]
+	}
+	w.write(" " + marker + "\n")
+	lines := strings.Split(s, "\n")
+	// Empty lines at the end of the block are written with indenting, otherwise
+	// they would not be part of the block when parsing.
+	n := len(lines)
+	for n > 0 && lines[n-1] == "" {
+		n--
+	}
+	for i, line := range lines {
+		if line == "" && i < n {
+			w.write("\n")
+		} else {
+			w.write(w.prefix + w.indentUnit + line + "\n")
+		}
+	}
+}
+
+// needsQuote returns whether s must be written as quoted string. Control
+// characters other than tab and newline, and invalid UTF-8 cannot be written as
+// is, not even in a multiline string. Single line strings starting with a quote
+// would be parsed as quoted string, and leading and trailing whitespace is easily
+// lost, e.g. by editors.
+func needsQuote(s string) bool {
+	if !utf8.ValidString(s) {
+		return true
+	}
+	for _, c := range s {
+		if c != '\t' && c != '\n' && !strconv.IsPrint(c) {
+			return true
+		}
+	}
+	if strings.Contains(s, "\n") {
+		return false
+	}
+	return strings.HasPrefix(s, `"`) || strings.TrimSpace(s) != s
+}
+
 func (w *writer) describeSlice(v reflect.Value) {
 	describeElem := func(vv reflect.Value) {
 		w.write(w.prefix)
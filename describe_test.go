@@ -2,7 +2,15 @@ package sconf
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
 	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -89,32 +97,18 @@ func TestDescribe(t *testing.T) {
 	var badInterface struct {
 		Interface interface{}
 	}
-	testBad(&badInterface, "unsupported value interface")
+	badInterface.Interface = 1
+	testBad(&badInterface, "type int not registered")
 
 	var badChan struct {
 		Channel chan int
 	}
 	testBad(&badChan, "unsupported value chan")
 
-	var badString = struct {
-		String string
-	}{
-		"multi\nline\nstring",
-	}
-	testBad(&badString, "unsupported multiline string")
-
-	type mystring string
-	var badCustomString = struct {
-		String mystring
-	}{
-		"multi\nline\nstring",
-	}
-	testBad(&badCustomString, "unsupported multiline string")
-
 	var badMap = struct {
-		Map map[int]string
+		Map map[complex128]string
 	}{}
-	testBad(&badMap, "map key must be string")
+	testBad(&badMap, "unsupported map key type complex128")
 
 	testGood := func(v interface{}, exp string) {
 		out := &bytes.Buffer{}
@@ -390,3 +384,674 @@ func TestMapNil(t *testing.T) {
 		t.Fatalf("parse: got %#v, expected %#v", nconfig, expConfig)
 	}
 }
+
+func TestMultilineString(t *testing.T) {
+	type mystring string
+	type xconfig struct {
+		String   string
+		Custom   mystring
+		List     []string
+		Map      map[string]string
+		Trailing string
+	}
+
+	config := xconfig{
+		String:   "-----BEGIN CERTIFICATE-----\nMIIB\n\n# not a comment\n-----END CERTIFICATE-----\n",
+		Custom:   "select *\n\tfrom t",
+		List:     []string{"a\nb", "|", "|-"},
+		Map:      map[string]string{"k": "\n\nx\n\n"},
+		Trailing: "\n",
+	}
+	exp := `String: |
+	-----BEGIN CERTIFICATE-----
+	MIIB
+
+	# not a comment
+	-----END CERTIFICATE-----
+Custom: |-
+	select *
+		from t
+List:
+	- |-
+		a
+		b
+	- |
+	- |-
+Map:
+	k: |
+
+
+		x
+		
+Trailing: |
+	
+`
+	out := &bytes.Buffer{}
+	if err := Write(out, config); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if out.String() != exp {
+		t.Fatalf("got:\n%s\n\nexpected:\n%s", out.String(), exp)
+	}
+	var nconfig xconfig
+	if err := Parse(out, &nconfig); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !reflect.DeepEqual(nconfig, config) {
+		t.Fatalf("parse: got %#v, expected %#v", nconfig, config)
+	}
+
+	// Empty lines are part of the block only when followed by more lines of the
+	// block, and comments at lower indent end the block.
+	src := "String: |-\n\ta\n\n\tb\n\n# comment\nCustom: x\nList:\n\t- y\nMap:\n\tk: v\nTrailing: t\n"
+	if err := Parse(strings.NewReader(src), &nconfig); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if nconfig.String != "a\n\nb" || nconfig.Custom != "x" {
+		t.Fatalf("parse: got %#v", nconfig)
+	}
+}
+
+func TestQuotedString(t *testing.T) {
+	type xconfig struct {
+		List []string
+		Map  map[string]string
+	}
+	config := xconfig{
+		List: []string{
+			"plain",
+			" leading space",
+			"trailing tab\t",
+			`"quoted"`,
+			"# comment lookalike",
+			"bell\a",
+			"multi\nline\r\n",
+			"\xff",
+			"",
+			"nil",
+		},
+		Map: map[string]string{"a": "nil", "b": " x "},
+	}
+	exp := `List:
+	- plain
+	- " leading space"
+	- "trailing tab\t"
+	- "\"quoted\""
+	- # comment lookalike
+	- "bell\a"
+	- "multi\nline\r\n"
+	- "\xff"
+	- 
+	- nil
+Map:
+	a: "nil"
+	b: " x "
+`
+	out := &bytes.Buffer{}
+	if err := Write(out, config); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if out.String() != exp {
+		t.Fatalf("got:\n%s\n\nexpected:\n%s", out.String(), exp)
+	}
+	var nconfig xconfig
+	if err := Parse(out, &nconfig); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !reflect.DeepEqual(nconfig, config) {
+		t.Fatalf("parse: got %#v, expected %#v", nconfig, config)
+	}
+
+	// Values of other types with text nil are quoted too.
+	type yconfig struct {
+		Ptr  map[string]*string
+		Text map[string]word
+	}
+	snil := "nil"
+	yc := yconfig{map[string]*string{"k": &snil}, map[string]word{"k": "nil"}}
+	out.Reset()
+	if err := Write(out, yc); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if exp := "Ptr:\n\tk: \"nil\"\nText:\n\tk: \"nil\"\n"; out.String() != exp {
+		t.Fatalf("got %q, expected %q", out.String(), exp)
+	}
+	var nyc yconfig
+	if err := Parse(out, &nyc); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !reflect.DeepEqual(nyc, yc) {
+		t.Fatalf("parse: got %#v, expected %#v", nyc, yc)
+	}
+
+	err := Parse(strings.NewReader(`List:
+	- "unterminated
+Map:
+	a: b
+`), &nconfig)
+	if err == nil || err.Error() != `:2: parsing quoted string: invalid syntax` {
+		t.Fatalf("got error %v, expected error for bad quoted string", err)
+	}
+}
+
+// word is written as its text, for testing text that needs quoting.
+type word string
+
+func (w word) MarshalText() ([]byte, error) {
+	return []byte(w), nil
+}
+
+func (w *word) UnmarshalText(buf []byte) error {
+	*w = word(buf)
+	return nil
+}
+
+type color int
+
+func (c color) MarshalText() ([]byte, error) {
+	switch c {
+	case 0:
+		return []byte("red"), nil
+	case 1:
+		return []byte("green"), nil
+	}
+	return nil, fmt.Errorf("unknown color %d", c)
+}
+
+func (c *color) UnmarshalText(buf []byte) error {
+	switch string(buf) {
+	case "red":
+		*c = 0
+	case "green":
+		*c = 1
+	default:
+		return fmt.Errorf("unknown color %q", buf)
+	}
+	return nil
+}
+
+func TestTextMarshaler(t *testing.T) {
+	type xconfig struct {
+		Color    color
+		Addr     netip.Addr
+		IP       net.IP
+		Int      *big.Int
+		Opt      netip.Addr `sconf:"optional"`
+		AddrList []netip.Addr
+		ColorMap map[string]color
+		PtrMap   map[string]*big.Int
+	}
+	config := xconfig{
+		Color:    1,
+		Addr:     netip.MustParseAddr("10.0.0.1"),
+		IP:       net.ParseIP("2001:db8::1"),
+		Int:      new(big.Int).Lsh(big.NewInt(1), 100),
+		AddrList: []netip.Addr{netip.MustParseAddr("::1"), netip.MustParseAddr("127.0.0.1")},
+		ColorMap: map[string]color{"a": 0, "b": 1},
+		PtrMap:   map[string]*big.Int{"x": big.NewInt(-1)},
+	}
+	exp := `Color: green
+Addr: 10.0.0.1
+IP: 2001:db8::1
+Int: 1267650600228229401496703205376
+AddrList:
+	- ::1
+	- 127.0.0.1
+ColorMap:
+	a: red
+	b: green
+PtrMap:
+	x: -1
+`
+	out := &bytes.Buffer{}
+	if err := Write(out, config); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if out.String() != exp {
+		t.Fatalf("got:\n%s\n\nexpected:\n%s", out.String(), exp)
+	}
+	var nconfig xconfig
+	if err := Parse(out, &nconfig); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !reflect.DeepEqual(nconfig, config) {
+		t.Fatalf("parse: got %#v, expected %#v", nconfig, config)
+	}
+
+	err := Parse(strings.NewReader("Color: blue\n"), &nconfig)
+	if err == nil || err.Error() != `:1: parsing sconf.color: unknown color "blue"` {
+		t.Fatalf("got error %v, expected error for bad color", err)
+	}
+
+	config.Color = 2
+	if err := Write(&bytes.Buffer{}, config); err == nil || err.Error() != "unknown color 2" {
+		t.Fatalf("got error %v, expected error for bad color", err)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	type xconfig struct {
+		Timeout time.Duration `sconf:"optional" sconf-default:"10s" sconf-doc:"Timeout for requests."`
+		Name    string        `sconf:"optional" sconf-default:"\" x \""`
+		Zero    int           `sconf:"optional" sconf-default:"0"`
+		Port    int
+	}
+
+	var config xconfig
+	if err := Parse(strings.NewReader("Port: 1\n"), &config); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if exp := (xconfig{10 * time.Second, " x ", 0, 1}); config != exp {
+		t.Fatalf("got %#v, expected %#v", config, exp)
+	}
+	if err := Parse(strings.NewReader("Timeout: 1s\nPort: 1\n"), &config); err != nil {
+		t.Fatalf("parse: %v", err)
+	} else if config.Timeout != time.Second {
+		t.Fatalf("got timeout %v, expected 1s", config.Timeout)
+	}
+
+	// Zero values are written when they differ from the default.
+	config = xconfig{Port: 1, Name: " x "}
+	out := &bytes.Buffer{}
+	if err := Write(out, config); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	exp := `Timeout: 0s
+Name: " x "
+Port: 1
+`
+	if out.String() != exp {
+		t.Fatalf("got:\n%s\n\nexpected:\n%s", out.String(), exp)
+	}
+	var nconfig xconfig
+	if err := Parse(out, &nconfig); err != nil {
+		t.Fatalf("parse: %v", err)
+	} else if nconfig != config {
+		t.Fatalf("got %#v, expected %#v", nconfig, config)
+	}
+
+	out = &bytes.Buffer{}
+	if err := Describe(out, config); err != nil {
+		t.Fatalf("describe: %v", err)
+	}
+	exp = `# Timeout for requests. (optional, default 10s)
+Timeout: 0s
+
+# (optional, default " x ")
+Name: " x "
+
+# (optional, default 0)
+Zero: 0
+Port: 1
+`
+	if out.String() != exp {
+		t.Fatalf("got:\n%s\n\nexpected:\n%s", out.String(), exp)
+	}
+
+	var badConfig struct {
+		Timeout time.Duration `sconf:"optional" sconf-default:"10"`
+	}
+	err := Parse(strings.NewReader(""), &badConfig)
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Kind != KindTag || perr.Err.Error() != `parsing sconf-default tag of field Timeout: parsing duration: time: missing unit in duration "10"` {
+		t.Fatalf("got %v, expected error for bad default", err)
+	}
+	if err := Describe(&bytes.Buffer{}, badConfig); err == nil {
+		t.Fatalf("describe: got nil, expected error for bad default")
+	}
+
+	var requiredConfig struct {
+		Port int `sconf-default:"1"`
+	}
+	err = Parse(strings.NewReader("Port: 1\n"), &requiredConfig)
+	if !errors.As(err, &perr) || perr.Kind != KindTag {
+		t.Fatalf("got %v, expected error for default on required field", err)
+	}
+}
+
+type CommonTLS struct {
+	CertFile string
+	KeyFile  string `sconf:"optional"`
+}
+
+type Limits struct {
+	MaxConns int `sconf:"optional"`
+	Port     int // Shadowed by Listener.Port.
+}
+
+func TestEmbedded(t *testing.T) {
+	type listener struct {
+		CommonTLS
+		*Limits
+		Port int
+	}
+
+	const src = `CertFile: cert.pem
+MaxConns: 10
+Port: 443
+`
+	var config listener
+	if err := Parse(strings.NewReader(src), &config); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if config.CertFile != "cert.pem" || config.Limits == nil || config.MaxConns != 10 || config.Port != 443 || config.Limits.Port != 0 {
+		t.Fatalf("got %#v, expected embedded fields set", config)
+	}
+
+	out := &bytes.Buffer{}
+	if err := Write(out, config); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if out.String() != src {
+		t.Fatalf("got:\n%s\n\nexpected:\n%s", out.String(), src)
+	}
+
+	// Nil embedded pointers are written as zero values.
+	out = &bytes.Buffer{}
+	if err := Describe(out, listener{}); err != nil {
+		t.Fatalf("describe: %v", err)
+	}
+	exp := `CertFile: 
+
+# (optional)
+KeyFile: 
+
+# (optional)
+MaxConns: 0
+Port: 0
+`
+	if out.String() != exp {
+		t.Fatalf("got:\n%s\n\nexpected:\n%s", out.String(), exp)
+	}
+
+	// Required fields of embedded structs must be present.
+	err := Parse(strings.NewReader("Port: 443\n"), &listener{})
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Kind != KindMissingKey || perr.KeyPath != "CertFile" {
+		t.Fatalf("got %v, expected missing key CertFile", err)
+	}
+
+	// Embedded structs are not keys.
+	err = Parse(strings.NewReader("CommonTLS:\n\tCertFile: cert.pem\n"), &listener{})
+	if !errors.As(err, &perr) || perr.Kind != KindUnknownKey {
+		t.Fatalf("got %v, expected unknown key", err)
+	}
+
+	// Promoted fields with the same name at the same depth are ambiguous.
+	type tls2 struct{ CertFile string }
+	var bad struct {
+		CommonTLS
+		Tls2 tls2
+		tls2
+	}
+	err = Parse(strings.NewReader("CertFile: x\n"), &bad)
+	if !errors.As(err, &perr) || perr.Kind != KindType || !strings.Contains(perr.Err.Error(), "ambiguous field CertFile") {
+		t.Fatalf("got %v, expected ambiguous field error", err)
+	}
+	if err := Write(&bytes.Buffer{}, bad); err == nil || !strings.Contains(err.Error(), "ambiguous field CertFile") {
+		t.Fatalf("got %v, expected ambiguous field error", err)
+	}
+}
+
+func TestMapKeys(t *testing.T) {
+	type name string
+	type xconfig struct {
+		Ints      map[int8]string
+		Names     map[name]int
+		Bools     map[bool]int
+		Floats    map[float64]int
+		Durations map[time.Duration]int
+		Addrs     map[netip.Addr]string
+	}
+
+	const src = `Ints:
+	-5: minus
+	2: two
+	10: ten
+Names:
+	a: 1
+	b: 2
+Bools:
+	false: 0
+	true: 1
+Floats:
+	0.5: 1
+	1e+06: 2
+Durations:
+	1s: 1
+	1m0s: 60
+Addrs:
+	10.0.0.2: b
+	10.0.0.10: a
+`
+	var config xconfig
+	if err := Parse(strings.NewReader(src), &config); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if config.Ints[-5] != "minus" || config.Names["b"] != 2 || config.Bools[true] != 1 || config.Floats[1e6] != 2 || config.Durations[time.Minute] != 60 || config.Addrs[netip.MustParseAddr("10.0.0.10")] != "a" {
+		t.Fatalf("got %#v, expected all keys", config)
+	}
+
+	// Keys are written in order of their type.
+	out := &bytes.Buffer{}
+	if err := Write(out, config); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if out.String() != src {
+		t.Fatalf("got:\n%s\n\nexpected:\n%s", out.String(), src)
+	}
+
+	test := func(old, new string, kind ErrorKind, exp string) {
+		t.Helper()
+		err := Parse(strings.NewReader(strings.Replace(src, old, new, 1)), &xconfig{})
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Kind != kind || perr.Err.Error() != exp {
+			t.Fatalf("got %v, expected %s error %q", err, kind, exp)
+		}
+	}
+	test("2: two", "200: two", KindValue, `parsing map key "200": strconv.ParseInt: parsing "200": value out of range`)
+	test("2: two", "10: two", KindDuplicateKey, "duplicate key in map")
+	test("2: two", "010: two", KindDuplicateKey, "duplicate key in map")
+	test("false: 0", "no: 0", KindValue, `parsing map key "no": bad boolean value`)
+	test("10.0.0.2: b", "bogus: b", KindValue, `parsing map key "bogus": ParseAddr("bogus"): unable to parse IP`)
+
+	var bad struct {
+		Map map[[2]int]string
+	}
+	err := Parse(strings.NewReader("Map:\n\tx: y\n"), &bad)
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Kind != KindType || perr.Err.Error() != "unsupported map key type [2]int" {
+		t.Fatalf("got %v, expected error for unsupported key type", err)
+	}
+
+	var badKey struct {
+		Map map[string]int
+	}
+	badKey.Map = map[string]int{"a:b": 1}
+	if err := Write(&bytes.Buffer{}, badKey); err == nil || err.Error() != `map key "a:b" cannot be written` {
+		t.Fatalf("got %v, expected error for key with colon", err)
+	}
+	badKey.Map = map[string]int{"- x": 1}
+	if err := Write(&bytes.Buffer{}, badKey); err == nil || err.Error() != `map key "- x" cannot be written` {
+		t.Fatalf("got %v, expected error for key like list item", err)
+	}
+}
+
+func TestArray(t *testing.T) {
+	type xconfig struct {
+		Words [3]string
+		Key   [4]byte
+		Grid  [2][2]int
+		Data  []byte
+	}
+
+	const src = `Words:
+	- a
+	- b
+	- c
+Key: AQIDBA==
+Grid:
+	-
+		- 1
+		- 2
+	-
+		- 3
+		- 4
+Data: BQY=
+`
+	var config xconfig
+	if err := Parse(strings.NewReader(src), &config); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	exp := xconfig{[3]string{"a", "b", "c"}, [4]byte{1, 2, 3, 4}, [2][2]int{{1, 2}, {3, 4}}, []byte{5, 6}}
+	if !reflect.DeepEqual(config, exp) {
+		t.Fatalf("got %#v, expected %#v", config, exp)
+	}
+
+	out := &bytes.Buffer{}
+	if err := Write(out, config); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if out.String() != src {
+		t.Fatalf("got:\n%s\n\nexpected:\n%s", out.String(), src)
+	}
+
+	test := func(old, new string, line int, keyPath, exp string) {
+		t.Helper()
+		err := Parse(strings.NewReader(strings.Replace(src, old, new, 1)), &xconfig{})
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Kind != KindValue || perr.Line != line || perr.KeyPath != keyPath || perr.Err.Error() != exp {
+			t.Fatalf("got %v, expected %q at line %d, key %s", err, exp, line, keyPath)
+		}
+	}
+	test("\t- c\n", "\t- c\n\t- d\n", 5, "Words[3]", "too many elements, array has length 3")
+	test("\t- c\n", "", 1, "Words", "too few elements, got 2, array has length 3")
+	test("Key: AQIDBA==", "Key: AQID", 5, "Key", "got 3 bytes, expected 4")
+	test("\t\t- 4\n", "", 10, "Grid[1]", "too few elements, got 1, array has length 2")
+}
+
+func TestStdTypes(t *testing.T) {
+	type xconfig struct {
+		Time     time.Time
+		Addr     netip.Addr
+		Prefix   netip.Prefix
+		AddrPort netip.AddrPort
+		IP       net.IP
+		Network  net.IPNet
+		URL      *url.URL
+		Regexp   *regexp.Regexp
+		Mode     os.FileMode
+		Modes    map[string]os.FileMode
+	}
+
+	const src = `Time: 2024-02-03T04:05:06Z
+Addr: 10.0.0.1
+Prefix: 10.0.0.0/8
+AddrPort: [::1]:25
+IP: 192.168.0.1
+Network: 192.168.0.0/16
+URL: https://example.org/path?q=1
+Regexp: ^a+$
+Mode: 0644
+Modes:
+	dir: 020000000755
+`
+	var config xconfig
+	if err := Parse(strings.NewReader(src), &config); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !config.Time.Equal(time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)) || config.AddrPort.Port() != 25 || config.Network.String() != "192.168.0.0/16" || config.URL.Host != "example.org" || !config.Regexp.MatchString("aa") || config.Mode != 0644 || config.Modes["dir"] != os.ModeDir|0755 {
+		t.Fatalf("got %#v, expected parsed values", config)
+	}
+
+	out := &bytes.Buffer{}
+	if err := Write(out, config); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if out.String() != src {
+		t.Fatalf("got:\n%s\n\nexpected:\n%s", out.String(), src)
+	}
+
+	test := func(old, new string, line, column int, exp string) {
+		t.Helper()
+		err := Parse(strings.NewReader(strings.Replace(src, old, new, 1)), &xconfig{})
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Kind != KindValue || perr.Line != line || perr.Column != column || perr.Err.Error() != exp {
+			t.Fatalf("got %v, expected %q at %d:%d", err, exp, line, column)
+		}
+	}
+	test("Regexp: ^a+$", "Regexp: a(", 8, 9, "parsing regexp.Regexp: error parsing regexp: missing closing ): `a(`")
+	test("Mode: 0644", "Mode: 0649", 9, 7, `parsing octal file mode: strconv.ParseUint: parsing "0649": invalid syntax`)
+	test("Network: 192.168.0.0/16", "Network: 192.168.0.0", 6, 10, "parsing ip network: invalid CIDR address: 192.168.0.0")
+}
+
+func TestEncoder(t *testing.T) {
+	type xconfig struct {
+		Name  string `sconf-doc:"Name of the service, used in logging and in the greeting of the protocol."`
+		Ratio float64
+		Sub   struct {
+			Text  string
+			Ports []int `sconf:"optional"`
+		}
+	}
+	var config xconfig
+	config.Name = "x"
+	config.Ratio = 0.25
+	config.Sub.Text = "line1\nline2\n"
+
+	out := &bytes.Buffer{}
+	opts := EncoderOptions{Docs: true, Indent: "  ", MaxWidth: 40, FloatFormat: "%g"}
+	if err := NewEncoder(out, opts).Encode(config); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	exp := `# Name of the service, used in logging and
+# in the greeting of the protocol.
+Name: x
+Ratio: 0.25
+Sub:
+  Text: |
+    line1
+    line2
+`
+	if out.String() != exp {
+		t.Fatalf("got:\n%s\n\nexpected:\n%s", out.String(), exp)
+	}
+
+	var nconfig xconfig
+	if err := NewDecoder(strings.NewReader(out.String()), DecoderOptions{Indent: "  "}).Decode(&nconfig); err != nil {
+		t.Fatalf("decode: %v", err)
+	} else if !reflect.DeepEqual(nconfig, config) {
+		t.Fatalf("got %#v, expected %#v", nconfig, config)
+	}
+
+	// Tabs are expected by default.
+	if err := Parse(strings.NewReader(out.String()), &xconfig{}); err == nil {
+		t.Fatalf("parse with spaces: got nil, expected error")
+	}
+
+	// Zero values are written with KeepZero, comments not wrapped with negative MaxWidth.
+	out = &bytes.Buffer{}
+	opts = EncoderOptions{Docs: true, KeepZero: true, MaxWidth: -1}
+	if err := NewEncoder(out, opts).Encode(config); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	exp = `# Name of the service, used in logging and in the greeting of the protocol.
+Name: x
+Ratio: 0.250000
+Sub:
+	Text: |
+		line1
+		line2
+
+	# (optional)
+	Ports:
+		- 0
+`
+	if out.String() != exp {
+		t.Fatalf("got:\n%s\n\nexpected:\n%s", out.String(), exp)
+	}
+
+	if err := NewEncoder(&bytes.Buffer{}, EncoderOptions{Indent: "x"}).Encode(config); err == nil {
+		t.Fatalf("got nil, expected error for bad indent")
+	}
+}
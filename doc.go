@@ -59,8 +59,67 @@ with a key, followed by a colon, followed by a value. Basic values like
 strings, ints, bools run to the end of the line. The leading space after a
 colon or dash is removed. Other values like maps and lists start on a new line,
 with an additional level of indenting. List values start with a dash. Empty
-lines are allowed. Multiline strings are not possible. Strings do not have
-escaped characters.
+lines are allowed. Strings do not have escaped characters, unless they start
+with a double quote: Such values are parsed as Go double-quoted strings, with
+escapes like \t and \n, and can hold leading and trailing whitespace or
+control characters. Writing a config only uses the quoted form when a string
+would otherwise not be parsed back as the same value.
+
+Integers can be written in decimal, or with prefix 0x for hexadecimal, 0o for
+octal or 0b for binary, and can have underscores between digits, e.g.
+1_000_000. A leading zero does not make a number octal. Values that do not fit
+in the type of the field, e.g. 300 for an int8, are an error. Integers are
+written in decimal, or in the base set with word "hex", "octal" or "binary" in
+the "sconf" struct tag.
+
+Fields of type ByteSize are written with a unit, e.g. 512MiB or 1.5GB, and
+fields of type Percent with a percent sign, e.g. 10%.
+
+Arrays are written as lists, and must have exactly as many elements as the
+array type. Byte slices and byte arrays are written as base64.
+
+Map keys can be strings, integers, bools, floats, durations and types
+implementing encoding.TextUnmarshaler, parsed from the text before the colon.
+Keys are written in order of their value, or with their Compare method if they
+have one.
+
+A line "include <file>" reads the lines of another file in its place, at the
+same indent. It can be used to split a config file, e.g. with a file per
+account:
+
+	Accounts:
+		include accounts/alice.conf
+		include accounts/bob.conf
+
+Includes are only read by ParseFile, ParseFiles and Watcher, or by a Decoder
+with DecoderOptions.Includes set, not when parsing from a reader, which may be
+untrusted. Relative paths are resolved against the directory of the including
+file. Include cycles are an error, as is nesting includes more than 16 levels
+deep. Errors point to the line in the included file. A line that looks like a
+key/value, with ": " or ending with ":", is not an include.
+
+Types implementing encoding.TextUnmarshaler and encoding.TextMarshaler, such as
+netip.Addr, net.IP, time.Time (RFC 3339) and regexp.Regexp, are parsed and
+written as strings with UnmarshalText and MarshalText. Like time.Duration, the
+types url.URL, net.IPNet (CIDR notation) and os.FileMode (octal, e.g. 0644) are
+also parsed and written as single values. Types implementing Unmarshaler and
+Marshaler parse and write their value themselves, including any nested lines,
+through a Node.
+
+Multiline strings start with "|" as value, followed by the lines of the string
+with an additional level of indenting. Each line, including the last, ends with
+a newline. With "|-" instead of "|", the newline after the last line is left
+out. Lines in a multiline string are not interpreted, so lines starting with "#"
+are part of the string. Empty lines are part of the string when followed by
+more lines of the string:
+
+	Certificate: |
+		-----BEGIN CERTIFICATE-----
+		MIIBszCCAVmgAwIBAgIU...
+		-----END CERTIFICATE-----
+	Query: |-
+		select *
+		from mytable
 
 And the struct that generated this:
 
@@ -81,6 +140,77 @@ And the struct that generated this:
 		} `sconf-doc:"nested structs work just as well"`
 	}
 
+The key of a field is its name, unless set with "name=..." in the "sconf" struct
+tag. Old keys can be kept working with one or more "alias=..." words. Using an
+alias is reported through the Warn callback of DecoderOptions. Write and
+Describe always use the key:
+
+	ListenAddr string `sconf:"name=Listen,alias=Addr"`
+
+Fields with "deprecated" in the "sconf" struct tag are optional and still
+parsed, but their use is reported through the Warn callback, with the message
+from an "sconf-deprecated" struct tag. With the Strict option, using them is an
+error. Describe leaves deprecated fields out.
+
+Fields of interface type hold one of the struct types made available with
+Register. Their value is a block with a "Type" key naming the registered type,
+followed by the fields of the struct. A nil interface is written as "nil".
+
+Fields of anonymous embedded structs, and pointers to structs, are keys of the
+struct they are embedded in, as if they were declared there. Promoted fields
+with the same name at the same depth are an error.
+
+Optional fields can have a default value in an "sconf-default" struct tag,
+written like a value in a config file. The default is set when the key is not
+present in the parsed struct, and is mentioned in the comments written by
+Describe and WriteDocs:
+
+	Timeout time.Duration `sconf:"optional" sconf-default:"10s"`
+
+Values can be checked while parsing with constraints in an "sconf-validate"
+struct tag, separated by commas: "min=..." and "max=..." for bounds on numbers
+and on the length of strings, lists and maps, "oneof=a|b|c" for strings and
+numbers, "pattern=..." with a regular expression for strings, and "nonempty".
+A pattern runs to the end of the tag. Values that do not satisfy a constraint
+are reported with their line, and constraints are mentioned in the comments
+written by Describe and WriteDocs:
+
+	Port int `sconf-validate:"min=1,max=65535"`
+
+Rules involving multiple fields can be checked by implementing Validator on a
+struct type. Validate is called after the struct has been parsed, and an error
+is reported with the range of lines of the struct:
+
+	func (c TLSConfig) Validate() error {
+		if c.Enabled && c.CertFile == "" {
+			return errors.New("CertFile required when TLS is enabled")
+		}
+		return nil
+	}
+
+Parse, ParseFile, Describe, Write and WriteDocs use default options. A Decoder
+and Encoder can be configured with DecoderOptions and EncoderOptions, e.g. to
+collect all errors, ignore unknown keys, indent with spaces, or change the
+width at which comments are wrapped.
+
+ParseFiles reads a base config file followed by override files, e.g. a file
+shipped with a package and a file with local changes. Structs and maps are
+merged key by key, other values are replaced, as are lists unless their field
+has "append" in the "sconf" struct tag. Required keys only need to be present
+in one of the files.
+
+A Watcher keeps a config up to date with its file, for daemons that pick up
+changes without restarting. It parses the file again when it changes, and
+publishes the new config only if it is valid.
+
+Package ast parses config files into a syntax tree that keeps comments, empty
+lines and positions, for tools that work on config files without a Go type. The
+parser of this package reads files through it. Update uses it to write a
+changed value back into an existing config file, rewriting only the lines of
+changed values and keeping comments and the order of keys. Get, Set and Delete
+read and change a single value in a parsed file by path, like "Mail.SMTP.Host"
+or "Admins[0]", optionally checking the changed file against a config type.
+
 See cmd/sconfexample/main.go for more details.
 
 In practice, you will mostly have nested maps:
@@ -0,0 +1,113 @@
+package sconf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/mjl-/sconf/ast"
+)
+
+// Get parses the value at path in f into dst, which must be a pointer. Paths
+// have the form of ParseError.KeyPath, e.g. "Mail.SMTP.Host", "Accounts.bob"
+// or "Admins[0]", see ast.ParsePath. Errors are returned as *ParseError.
+func Get(f *ast.File, path string, dst interface{}) error {
+	n, err := f.Get(path)
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr {
+		return errors.New("destination not a pointer")
+	}
+	p, ok := nodeParser(n, DecoderOptions{Indent: f.Indent})
+	if !ok {
+		return fmt.Errorf("%s: value has include directive", path)
+	}
+	p.keyPath = path
+	return p.run(func() {
+		p.leave(n.Value)
+		v.Elem().Set(p.parseValue(v.Elem()))
+		if p.next() {
+			p.stop(KindSyntax, fmt.Sprintf("unexpected line %q", p.line))
+		}
+	})
+}
+
+// Set sets the value at path in f to v, written as by Write, adding missing
+// keys, see ast.File.Set. Lines nested under path are replaced.
+//
+// If check is not nil, the changed file is parsed into it, a pointer to a config
+// struct, and f is only changed if parsing succeeds. This validates the new
+// value against its field, including struct tags and Validate methods. Included
+// files are read as with ParseFile if f.Path is set, otherwise a file with an
+// include directive fails the check.
+func Set(f *ast.File, path string, v, check interface{}) error {
+	if v == nil {
+		return errors.New("cannot set nil value")
+	}
+	var b bytes.Buffer
+	w := newWriter(&b, EncoderOptions{Indent: f.Indent})
+	err := w.run(func() {
+		w.describeValue(reflect.ValueOf(v))
+		w.flush()
+	})
+	if err != nil {
+		return err
+	}
+	value := strings.TrimPrefix(strings.TrimSuffix(b.String(), "\n"), " ")
+	return edit(f, check, func(nf *ast.File) error {
+		return nf.Set(path, value)
+	})
+}
+
+// Delete removes the value at path in f, with its nested lines. If check is not
+// nil, the changed file is parsed into it as for Set, and f is only changed if
+// parsing succeeds, e.g. to prevent removing a required key.
+func Delete(f *ast.File, path string, check interface{}) error {
+	return edit(f, check, func(nf *ast.File) error {
+		return nf.Delete(path)
+	})
+}
+
+// edit applies fn to a copy of f, parses the result into check if not nil, and
+// replaces f with the copy if that succeeds.
+func edit(f *ast.File, check interface{}, fn func(nf *ast.File) error) error {
+	nf := ast.Parse(f.Bytes(), f.Indent)
+	if err := fn(nf); err != nil {
+		return err
+	}
+	// Parse again, for line numbers of added lines.
+	buf := nf.Bytes()
+	nf = ast.Parse(buf, f.Indent)
+	nf.Path = f.Path
+	if check != nil {
+		opts := DecoderOptions{Indent: f.Indent, Path: f.Path, Includes: f.Path != ""}
+		if err := NewDecoder(bytes.NewReader(buf), opts).Decode(check); err != nil {
+			return err
+		}
+	}
+	*f = *nf
+	return nil
+}
+
+// nodeParser returns a parser positioned at n, for parsing its value and nested
+// lines. It returns false if the nested lines have an include directive, for
+// which the file would have to be known.
+func nodeParser(n *ast.Node, opts DecoderOptions) (*parser, bool) {
+	lines := descendants(n)
+	for _, c := range lines {
+		if c.Kind == ast.Include {
+			return nil, false
+		}
+	}
+
+	p := newParser(lines, opts)
+	p.prefix = n.Indent
+	p.lineNode = n
+	p.linenumber = n.Line
+	p.raw = n.Raw
+	return p, true
+}
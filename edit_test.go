@@ -0,0 +1,141 @@
+package sconf
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/mjl-/sconf/ast"
+)
+
+func TestEdit(t *testing.T) {
+	type smtp struct {
+		Host string
+		Port int `sconf:"optional" sconf-validate:"max=65535"`
+	}
+	type xconfig struct {
+		Mail struct {
+			SMTP smtp
+		}
+		Admins   []string
+		Accounts map[string]struct {
+			Domain string
+		} `sconf:"optional"`
+	}
+
+	const src = `Mail:
+	SMTP:
+		# The relay.
+		Host: smtp1
+Admins:
+	- alice
+`
+	f := ast.Parse([]byte(src), "")
+
+	var host string
+	if err := Get(f, "Mail.SMTP.Host", &host); err != nil || host != "smtp1" {
+		t.Fatalf("get: got %q %v, expected smtp1", host, err)
+	}
+	var s smtp
+	if err := Get(f, "Mail.SMTP", &s); err != nil || s.Host != "smtp1" {
+		t.Fatalf("get struct: got %v %v", s, err)
+	}
+	var port int
+	err := Get(f, "Mail.SMTP.Host", &port)
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Line != 4 || perr.KeyPath != "Mail.SMTP.Host" {
+		t.Fatalf("get as int: got %#v, expected parse error at line 4", err)
+	}
+
+	var c xconfig
+	if err := Set(f, "Mail.SMTP.Port", 587, &c); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if c.Mail.SMTP.Port != 587 {
+		t.Fatalf("checked config has port %d, expected 587", c.Mail.SMTP.Port)
+	}
+	if err := Set(f, "Admins", []string{"bob", "carol"}, nil); err != nil {
+		t.Fatalf("set list: %v", err)
+	}
+	if err := Set(f, "Accounts.bob", map[string]string{"Domain": "example.org"}, &c); err != nil {
+		t.Fatalf("set map entry: %v", err)
+	}
+	exp := `Mail:
+	SMTP:
+		# The relay.
+		Host: smtp1
+		Port: 587
+Admins:
+	- bob
+	- carol
+Accounts:
+	bob:
+		Domain: example.org
+`
+	if s := string(f.Bytes()); s != exp {
+		t.Fatalf("got:\n%s\nexpected:\n%s", s, exp)
+	}
+	if n, err := f.Get("Accounts.bob.Domain"); err != nil || n.Line != 11 {
+		t.Fatalf("get added line: got %v %v, expected line 11", n, err)
+	}
+
+	// Checks fail, leaving the file unchanged.
+	err = Set(f, "Mail.SMTP.Port", 100000, &c)
+	if !errors.As(err, &perr) || perr.Kind != KindValidation {
+		t.Fatalf("set invalid port: got %v, expected validation error", err)
+	}
+	err = Delete(f, "Mail.SMTP.Host", &c)
+	if !errors.As(err, &perr) || perr.Kind != KindMissingKey {
+		t.Fatalf("delete required key: got %v, expected missing key error", err)
+	}
+	if s := string(f.Bytes()); s != exp {
+		t.Fatalf("file changed after failed checks:\n%s", s)
+	}
+
+	if err := Delete(f, "Accounts", &c); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := Set(f, "Admins", nil, nil); err == nil {
+		t.Fatalf("set nil: expected error")
+	}
+}
+
+func TestEditInclude(t *testing.T) {
+	type xconfig struct {
+		Name     string
+		Port     int
+		Accounts map[string]struct {
+			Quota int
+			Notes string
+		}
+		List []string
+	}
+
+	buf, err := os.ReadFile("testdata/include/main.conf")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	f := ast.Parse(buf, "")
+
+	// Without path, includes are not read, failing checks.
+	var c xconfig
+	err = Set(f, "Name", "x", &c)
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Kind != KindInclude {
+		t.Fatalf("set without path: got %v, expected include error", err)
+	}
+
+	f.Path = "testdata/include/main.conf"
+	if err := Set(f, "Name", "x", &c); err != nil || c.Name != "x" || c.Port != 25 {
+		t.Fatalf("set with path: got %v, %#v", err, c)
+	}
+	if f.Path != "testdata/include/main.conf" {
+		t.Fatalf("path not kept after set, got %q", f.Path)
+	}
+	// Keys of included files are checked too. Set does not follow includes, so
+	// it adds a key that is already in common.conf.
+	err = Set(f, "Port", 26, &c)
+	if !errors.As(err, &perr) || perr.Kind != KindDuplicateKey {
+		t.Fatalf("set included key: got %v, expected duplicate key error", err)
+	}
+}
@@ -0,0 +1,102 @@
+package sconf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrorKind classifies a ParseError.
+type ErrorKind int
+
+const (
+	KindSyntax       ErrorKind = iota + 1 // Malformed line, e.g. missing colon, space or indent.
+	KindUnknownKey                        // Key not present in the destination type.
+	KindDuplicateKey                      // Key present more than once in a struct or map.
+	KindMissingKey                        // Required key not present.
+	KindValue                             // Value could not be parsed, e.g. a bad integer.
+	KindType                              // Destination type cannot be parsed into.
+	KindIO                                // Error reading the input.
+	KindInclude                           // Include could not be read, or is nested too deep.
+	KindTag                               // Invalid struct tag on the destination type.
+	KindValidation                        // Value does not satisfy a validation constraint.
+	KindDeprecated                        // Deprecated key, reported as warning.
+)
+
+var kindNames = map[ErrorKind]string{
+	KindSyntax:       "syntax",
+	KindUnknownKey:   "unknown key",
+	KindDuplicateKey: "duplicate key",
+	KindMissingKey:   "missing key",
+	KindValue:        "value",
+	KindType:         "type",
+	KindIO:           "io",
+	KindInclude:      "include",
+	KindTag:          "tag",
+	KindValidation:   "validation",
+	KindDeprecated:   "deprecated",
+}
+
+func (k ErrorKind) String() string {
+	if s, ok := kindNames[k]; ok {
+		return s
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+// ParseError is the error returned by Parse and ParseFile for problems in a
+// config file. Use errors.As to get at the details.
+type ParseError struct {
+	Path    string    // File name as passed to ParseFile, empty for Parse.
+	Line    int       // 1-based line number, 0 if no line was read.
+	EndLine int       // Last line for errors about multiple lines, e.g. a struct failing Validate, 0 otherwise.
+	Column  int       // 1-based byte offset in Line, 0 if not known.
+	KeyPath string    // Path to the key, e.g. "Database.Hosts[2].Port".
+	RawLine string    // The offending line, without newline. Empty if not about a single line.
+	Kind    ErrorKind // Class of error.
+	Err     error     // Underlying error.
+}
+
+func (e *ParseError) Error() string {
+	if e.EndLine > e.Line {
+		return fmt.Sprintf("%s:%d-%d: %v", e.Path, e.Line, e.EndLine, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %v", e.Path, e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ParseErrors is returned by a Decoder with AllErrors set, holding all errors
+// found in a file, in order of occurrence.
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	l := make([]string, len(e))
+	for i, err := range e {
+		l[i] = err.Error()
+	}
+	return strings.Join(l, "\n")
+}
+
+// Unwrap returns the individual errors, for use with errors.As and errors.Is.
+func (e ParseErrors) Unwrap() []error {
+	l := make([]error, len(e))
+	for i, err := range e {
+		l[i] = err
+	}
+	return l
+}
+
+// keyPath returns the key path for key k in a struct or map at path.
+func keyPath(path, k string) string {
+	if path == "" {
+		return k
+	}
+	return path + "." + k
+}
+
+// indexPath returns the key path for list element i at path.
+func indexPath(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
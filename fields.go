@@ -0,0 +1,197 @@
+package sconf
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// field is a struct field that is read from and written to config files,
+// possibly promoted from an anonymous embedded struct.
+type field struct {
+	reflect.StructField
+	index   []int    // For FieldByIndex, through embedded structs.
+	key     string   // Key in config files, from "name=" in the sconf tag, or the field name.
+	aliases []string // Deprecated keys accepted while parsing, from "alias=" in the sconf tag.
+}
+
+// tagError is an error in a struct tag, as opposed to the structure of a type.
+type tagError struct {
+	error
+}
+
+type fieldsResult struct {
+	fields []field
+	err    error
+}
+
+// Fields by struct type, the result of structFields.
+var fieldsCache sync.Map // reflect.Type to fieldsResult
+
+// structFields returns the fields of struct type t that are parsed and written,
+// in order of declaration. Unexported and ignored fields are left out. Fields of
+// anonymous embedded structs and pointers to structs are included as if they were
+// fields of t, with the usual Go rules for shadowing. Embedded structs implementing
+// one of the marshal interfaces are regular fields. An error is returned for
+// promoted fields with the same name at the same depth.
+func structFields(t reflect.Type) ([]field, error) {
+	if r, ok := fieldsCache.Load(t); ok {
+		fr := r.(fieldsResult)
+		return fr.fields, fr.err
+	}
+	fields, err := structFields0(t, nil, map[reflect.Type]bool{})
+	if err == nil {
+		// Shallower fields shadow deeper fields, as in Go.
+		depths := map[string]int{}
+		for _, f := range fields {
+			if d, ok := depths[f.Name]; !ok || len(f.index) < d {
+				depths[f.Name] = len(f.index)
+			}
+		}
+		var l []field
+		for _, f := range fields {
+			if len(f.index) != depths[f.Name] {
+				continue
+			}
+			for _, o := range l {
+				if o.Name == f.Name {
+					err = fmt.Errorf("ambiguous field %s in %v, promoted from multiple embedded structs", f.Name, t)
+					break
+				}
+			}
+			if err != nil {
+				break
+			}
+			l = append(l, f)
+		}
+		fields = l
+	}
+	if err == nil {
+		// Keys and aliases must be unique after renaming.
+		keys := map[string]string{}
+		for _, f := range fields {
+			for _, k := range append([]string{f.key}, f.aliases...) {
+				if o, ok := keys[k]; ok {
+					err = tagError{fmt.Errorf("key %q of field %s already used by field %s in %v", k, f.Name, o, t)}
+					break
+				}
+				keys[k] = f.Name
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		fields = nil
+	}
+	fieldsCache.Store(t, fieldsResult{fields, err})
+	return fields, err
+}
+
+func structFields0(t reflect.Type, index []int, busy map[reflect.Type]bool) ([]field, error) {
+	if busy[t] {
+		return nil, fmt.Errorf("recursive embedded struct %v", t)
+	}
+	busy[t] = true
+	defer delete(busy, t)
+
+	var l []field
+	n := t.NumField()
+	for i := 0; i < n; i++ {
+		f := t.Field(i)
+		if isIgnore(f.Tag.Get("sconf")) {
+			continue
+		}
+		fi := append(append([]int{}, index...), i)
+		if et, ok := isEmbedded(f); ok {
+			fields, err := structFields0(et, fi, busy)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, fields...)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("sconf")
+		key := f.Name
+		if names := tagValues(tag, "name"); len(names) > 1 {
+			return nil, tagError{fmt.Errorf("sconf tag of field %s: multiple names", f.Name)}
+		} else if len(names) == 1 {
+			key = names[0]
+		}
+		aliases := tagValues(tag, "alias")
+		for _, k := range append([]string{key}, aliases...) {
+			if err := checkKey(k); err != nil {
+				return nil, tagError{fmt.Errorf("sconf tag of field %s: %v", f.Name, err)}
+			}
+		}
+		l = append(l, field{f, fi, key, aliases})
+	}
+	return l, nil
+}
+
+// checkKey returns an error if k cannot be used as key in a config file.
+func checkKey(k string) error {
+	if k == "" {
+		return fmt.Errorf("empty key")
+	}
+	if strings.TrimSpace(k) != k || strings.ContainsAny(k, ":\n") || strings.HasPrefix(k, "#") || k == "-" || strings.HasPrefix(k, "- ") || strings.HasPrefix(k, "include ") {
+		return fmt.Errorf("invalid key %q", k)
+	}
+	return nil
+}
+
+// tagValues returns the values of words "name=value" in an sconf tag.
+func tagValues(sconfTag, name string) []string {
+	var l []string
+	for _, s := range strings.Split(sconfTag, ",") {
+		if strings.HasPrefix(s, name+"=") {
+			l = append(l, s[len(name)+1:])
+		}
+	}
+	return l
+}
+
+// isEmbedded returns whether f is an embedded struct whose fields are promoted,
+// and the struct type.
+func isEmbedded(f reflect.StructField) (reflect.Type, bool) {
+	// With a name, an embedded struct is a regular field.
+	if !f.Anonymous || len(tagValues(f.Tag.Get("sconf"), "name")) > 0 {
+		return nil, false
+	}
+	t := f.Type
+	if t.Kind() == reflect.Ptr {
+		// Pointers to unexported types cannot be allocated while parsing.
+		if !f.IsExported() {
+			return nil, false
+		}
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || isText(t) || isMarshaler(t) || isStdType(t) || reflect.PtrTo(t).Implements(textUnmarshalerType) || reflect.PtrTo(t).Implements(unmarshalerType) {
+		return nil, false
+	}
+	return t, true
+}
+
+// fieldValue returns the value of field f in struct v. Nil pointers to embedded
+// structs are allocated if alloc is set, otherwise the zero value is returned,
+// which is not settable.
+func fieldValue(v reflect.Value, f field, alloc bool) reflect.Value {
+	for i, x := range f.index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Zero(f.Type)
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
@@ -1,5 +1,5 @@
 module github.com/mjl-/sconf
 
-go 1.12
+go 1.20
 
 require github.com/mjl-/xfmt v0.0.2
@@ -0,0 +1,97 @@
+package sconf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mjl-/sconf/ast"
+)
+
+// Maximum nesting of included files.
+const maxIncludeDepth = 16
+
+// includeFile is the state of a file that included another file, restored
+// when the end of the included file is reached.
+type includeFile struct {
+	input      []*ast.Node
+	path       string
+	inclPrefix string
+	linenumber int
+	raw        string
+}
+
+// include handles the include directive at the current line. Lines of the
+// included file are read as if they were in place of the directive, at the
+// same indent. Relative paths are resolved against the directory of the
+// current file.
+func (p *parser) include() {
+	n := p.lineNode
+	p.consume()
+	if !p.opts.Includes {
+		p.column = len(p.prefix) + 1
+		p.fail(p.error(KindInclude, fmt.Errorf("include not allowed, only when parsing a file or with DecoderOptions.Includes")))
+		return
+	}
+	p.column = len(p.prefix) + len("include ") + 1
+	name := strings.TrimSpace(n.Value)
+	if name == "" {
+		p.fail(p.error(KindInclude, fmt.Errorf("missing file name for include")))
+		return
+	}
+	if len(p.includes) >= maxIncludeDepth {
+		p.fail(p.error(KindInclude, fmt.Errorf("includes nested too deep, more than %d levels", maxIncludeDepth)))
+		return
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(p.path), path)
+	}
+	if err := p.checkIncludeCycle(path); err != nil {
+		p.fail(p.error(KindInclude, err))
+		return
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		p.fail(p.error(KindInclude, fmt.Errorf("include: %v", err)))
+		return
+	}
+
+	p.includes = append(p.includes, includeFile{p.input, p.path, p.inclPrefix, p.linenumber, p.raw})
+	p.input = fileLines(buf, p.indentUnit)
+	p.path = path
+	p.inclPrefix = p.prefix
+}
+
+// checkIncludeCycle returns an error if path is already being read.
+func (p *parser) checkIncludeCycle(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("include: %v", err)
+	}
+	paths := []string{p.path}
+	for _, f := range p.includes {
+		paths = append(paths, f.path)
+	}
+	for _, s := range paths {
+		if s == "" {
+			continue
+		}
+		if x, err := filepath.Abs(s); err == nil && x == abs {
+			return fmt.Errorf("include cycle, %s is already being read", path)
+		}
+	}
+	return nil
+}
+
+// endInclude continues with the file that included the current file.
+func (p *parser) endInclude() {
+	f := p.includes[len(p.includes)-1]
+	p.includes = p.includes[:len(p.includes)-1]
+	p.input = f.input
+	p.path = f.path
+	p.inclPrefix = f.inclPrefix
+	p.linenumber = f.linenumber
+	p.raw = f.raw
+}
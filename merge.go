@@ -0,0 +1,119 @@
+package sconf
+
+import (
+	"os"
+	"reflect"
+	"strings"
+)
+
+// ParseFiles reads config file base into dst, and then each override file, in
+// order. A key in an override file replaces the value of earlier files, except:
+//
+//   - The fields of a struct are merged, so an override file only needs the keys
+//     it changes.
+//   - The entries of a map are merged by key, with the value of an existing key
+//     merged as for structs. An entry with value "nil" replaces the value with the
+//     zero value.
+//   - Lists are replaced, unless the field has an "append" sconf tag, e.g.
+//     `sconf:"optional,append"`, in which case items of later files are added.
+//
+// Only the merged result must have all required keys, defaults are set for keys
+// not present in any file, and Validate methods are called on the merged
+// result. Errors about the merged result have the Path of base and line 0.
+// Errors in a file are returned as *ParseError.
+func ParseFiles(dst interface{}, base string, overrides ...string) error {
+	m := &merge{seen: map[string]bool{}, nils: map[string]bool{}}
+	for _, path := range append([]string{base}, overrides...) {
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = parse(src, dst, DecoderOptions{Path: path, Includes: true}, m)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	p := newParser(nil, DecoderOptions{Path: base})
+	p.merge = m
+	return p.run(func() {
+		p.checkMerged(reflect.ValueOf(dst).Elem(), "")
+	})
+}
+
+// merge tracks keys of files parsed with ParseFiles.
+type merge struct {
+	seen map[string]bool // Key paths of struct fields present in any file.
+	nils map[string]bool // Key paths of map entries with value "nil", not checked.
+}
+
+// forget removes the key paths nested in the value at path, for a value that is
+// replaced.
+func (m *merge) forget(path string) {
+	for k := range m.seen {
+		if strings.HasPrefix(k, path+".") || strings.HasPrefix(k, path+"[") {
+			delete(m.seen, k)
+		}
+	}
+}
+
+// mergeKey returns the text for map key kv, parsed from k, that is the same for
+// each file, for key paths.
+func mergeKey(kv reflect.Value, k string) string {
+	if s, err := mapKeyText(kv); err == nil {
+		return s
+	}
+	return k
+}
+
+// checkMerged sets defaults and checks for missing required keys in the merged
+// value v at path, and calls Validate, as done for each struct when parsing a
+// single file.
+func (p *parser) checkMerged(v reflect.Value, path string) {
+	t := v.Type()
+	if t == durationType || isStdType(t) || t.Kind() != reflect.Ptr && (reflect.PtrTo(t).Implements(unmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType)) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			p.checkMerged(v.Elem(), path)
+		}
+
+	case reflect.Interface:
+		// Interface values are replaced as a whole, not merged. Their struct was
+		// checked when parsed.
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			p.checkMerged(v.Index(i), indexPath(path, i))
+		}
+
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			if p.merge.nils[keyPath(path, mergeKey(k, ""))] {
+				continue
+			}
+			ev := reflect.New(t.Elem()).Elem()
+			ev.Set(v.MapIndex(k))
+			p.checkMerged(ev, keyPath(path, mergeKey(k, "")))
+			v.SetMapIndex(k, ev)
+		}
+
+	case reflect.Struct:
+		nerrs := len(p.errs)
+		p.missingFields(v, path, func(f field) bool {
+			return p.merge.seen[keyPath(path, f.key)]
+		})
+		for _, f := range p.structFields(t) {
+			if p.merge.seen[keyPath(path, f.key)] {
+				p.checkMerged(fieldValue(v, f, false), keyPath(path, f.key))
+			}
+		}
+		if len(p.errs) == nerrs {
+			p.validateStruct(v, path, 0)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package sconf
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type mergeLimits struct {
+	Connections int
+	Rate        int
+	Burst       int `sconf:"optional" sconf-default:"5"`
+}
+
+func (l mergeLimits) Validate() error {
+	if l.Burst > l.Rate {
+		return errors.New("burst larger than rate")
+	}
+	return nil
+}
+
+func TestParseFiles(t *testing.T) {
+	type account struct {
+		Domain string
+		Quota  ByteSize `sconf:"optional"`
+	}
+	type xconfig struct {
+		Hostname string
+		Listen   []string
+		Admins   []string `sconf:"append"`
+		Accounts map[string]account
+		Limits   mergeLimits
+		Relay    string `sconf:"name=SMTPRelay,alias=Relay"`
+		Timeout  int    `sconf:"optional,name=TimeoutSecs,alias=Timeout" sconf-default:"5"`
+	}
+
+	var c xconfig
+	if err := ParseFiles(&c, "testdata/merge/base.conf", "testdata/merge/local.conf"); err != nil {
+		t.Fatalf("parse files: %v", err)
+	}
+	exp := xconfig{
+		Hostname: "mail.example.org",
+		Listen:   []string{"0.0.0.0:25", "[::]:25"},
+		Admins:   []string{"root", "alice"},
+		Accounts: map[string]account{
+			"bob":   {"example.org", 2 * GiB},
+			"carol": {},
+			"dave":  {"example.com", 0},
+		},
+		Limits:  mergeLimits{100, 20, 5},
+		Relay:   "smtp.example.org",
+		Timeout: 10,
+	}
+	if !reflect.DeepEqual(c, exp) {
+		t.Fatalf("got:\n%#v\nexpected:\n%#v", c, exp)
+	}
+
+	// Only the base file.
+	c = xconfig{}
+	if err := ParseFiles(&c, "testdata/merge/base.conf"); err != nil {
+		t.Fatalf("parse base: %v", err)
+	}
+	if len(c.Accounts) != 2 || c.Limits.Rate != 10 {
+		t.Fatalf("unexpected config from base: %#v", c)
+	}
+
+	test := func(files []string, kind ErrorKind, exp string) {
+		t.Helper()
+		var c xconfig
+		err := ParseFiles(&c, files[0], files[1:]...)
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Kind != kind || err.Error() != exp {
+			t.Fatalf("got error %v, expected %s error %q", err, kind, exp)
+		}
+	}
+	// Required key missing in the merged result.
+	test([]string{"testdata/merge/base.conf", "testdata/merge/missing.conf"}, KindMissingKey, `testdata/merge/base.conf:0: missing required key "Domain"`)
+	// Override files alone are not complete.
+	test([]string{"testdata/merge/local.conf"}, KindMissingKey, `testdata/merge/local.conf:0: missing required key "SMTPRelay"`)
+	// Errors in a file point to that file.
+	test([]string{"testdata/merge/base.conf", "testdata/merge/bad.conf"}, KindValue, `testdata/merge/bad.conf:2: parsing integer: strconv.ParseInt: parsing "x": invalid syntax`)
+	// Validate is called on the merged result.
+	test([]string{"testdata/merge/base.conf", "testdata/merge/burst.conf"}, KindValidation, `testdata/merge/base.conf:0: burst larger than rate`)
+}
+
+func TestParseFilesInterface(t *testing.T) {
+	type xconfig struct {
+		Backend  backend
+		Backends []backend
+	}
+
+	var c xconfig
+	if err := ParseFiles(&c, "testdata/merge/backend.conf"); err != nil {
+		t.Fatalf("parse files: %v", err)
+	}
+	exp := xconfig{s3Backend{Bucket: "backups"}, []backend{&diskBackend{"/var/backups"}}}
+	if !reflect.DeepEqual(c, exp) {
+		t.Fatalf("got:\n%#v\nexpected:\n%#v", c, exp)
+	}
+
+	// An interface value is replaced, not merged.
+	c = xconfig{}
+	if err := ParseFiles(&c, "testdata/merge/backend.conf", "testdata/merge/backend-local.conf"); err != nil {
+		t.Fatalf("parse files: %v", err)
+	}
+	exp.Backend = &diskBackend{"/srv/backups"}
+	if !reflect.DeepEqual(c, exp) {
+		t.Fatalf("got:\n%#v\nexpected:\n%#v", c, exp)
+	}
+}
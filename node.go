@@ -0,0 +1,267 @@
+package sconf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/mjl-/sconf/ast"
+)
+
+// Unmarshaler is implemented by types that parse their value themselves,
+// including any nested lines. UnmarshalSconf is called with the node for the
+// value. Errors created with Node.Errorf or returned by Node.Decode point to
+// their line in the file. Other errors are reported at the line of n.
+type Unmarshaler interface {
+	UnmarshalSconf(n *Node) error
+}
+
+// Marshaler is implemented by types that write their value themselves. The
+// Value and Children of the returned node are written, Key and Item of the
+// returned node are ignored.
+type Marshaler interface {
+	MarshalSconf() (*Node, error)
+}
+
+var unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+
+// Node is a value in an sconf file, with its nested lines as children.
+//
+// A line "key: value" has Key "key" and Value "value". A line "- value" has
+// Item set and Value "value". Other lines, e.g. in a multiline string, only
+// have Value set to the line without indent. For the node passed to
+// UnmarshalSconf, only Value and Children are set.
+type Node struct {
+	Line     int     // 1-based line number, 0 if not read from a file.
+	Column   int     // 1-based byte offset of Value in the line.
+	Key      string  // Key of a key/value line.
+	Item     bool    // Whether this is a list item.
+	Value    string  // Text after colon or dash, without the separating space.
+	Children []*Node // Lines indented one level deeper, excluding comments and empty lines.
+
+	opts       DecoderOptions
+	path       string // File name, for errors.
+	keyPath    string
+	prefix     string      // Indent of this node.
+	raw        string      // Line of this node.
+	inclPrefix string      // Indent of include directive, prepended to raw and lines.
+	syntax     *ast.Node   // Line of this node in the syntax tree, for multiline strings.
+	lines      []*ast.Node // Nested lines, including comments and empty lines, for Decode.
+}
+
+// Errorf returns an error for the position of n. It can be returned from
+// UnmarshalSconf.
+func (n *Node) Errorf(format string, args ...interface{}) error {
+	return n.error(KindValue, fmt.Errorf(format, args...))
+}
+
+func (n *Node) error(kind ErrorKind, err error) *ParseError {
+	raw, column := fileLine(n.raw, n.Column, n.inclPrefix)
+	return &ParseError{
+		Path:    n.path,
+		Line:    n.Line,
+		Column:  column,
+		KeyPath: n.keyPath,
+		RawLine: raw,
+		Kind:    kind,
+		Err:     err,
+	}
+}
+
+// Decode parses the value of n, including its nested lines, into dst, which
+// must be a pointer. It parses as if dst had been the type of the value, so it
+// can be used to parse the value or a child node into a type chosen in
+// UnmarshalSconf. Errors are returned as *ParseError.
+func (n *Node) Decode(dst interface{}) error {
+	syntax, lines := n.syntax, n.lines
+	if n.Line == 0 && len(n.Children) > 0 {
+		// Constructed node, make the lines for the node and its children.
+		var b bytes.Buffer
+		w := newWriter(&b, EncoderOptions{Indent: n.opts.Indent})
+		w.prefix = n.prefix
+		err := w.run(func() {
+			w.write(w.prefix + "-")
+			if n.Value != "" {
+				w.write(" " + n.Value)
+			}
+			w.write("\n")
+			w.describeNodeChildren(n)
+			w.flush()
+		})
+		if err != nil {
+			return err
+		}
+		// Line numbers of the children start at 1, as for other constructed nodes.
+		lines = fileLines(b.Bytes(), n.opts.indent())
+		for _, l := range lines {
+			l.Line--
+		}
+		syntax, lines = lines[0], lines[1:]
+	}
+
+	p := n.parser(syntax, lines)
+	return p.run(func() {
+		v := reflect.ValueOf(dst)
+		if v.Kind() != reflect.Ptr {
+			p.stop(KindType, "destination not a pointer")
+		}
+		p.leave(n.Value)
+		v.Elem().Set(p.parseValue(v.Elem()))
+		if p.next() {
+			p.column = 1
+			p.stop(KindSyntax, fmt.Sprintf("unexpected line %q", p.line))
+		}
+	})
+}
+
+// parser returns a parser for lines, positioned at n, with syntax node syntax.
+func (n *Node) parser(syntax *ast.Node, lines []*ast.Node) *parser {
+	p := newParser(lines, n.opts)
+	p.allErrors = false
+	p.path = n.path
+	p.inclPrefix = n.inclPrefix
+	p.prefix = n.prefix
+	p.lineNode = syntax
+	p.linenumber = n.Line
+	p.raw = n.raw
+	p.column = n.Column
+	p.keyPath = n.keyPath
+	return p
+}
+
+// node consumes the current value and its nested lines and returns them as node.
+func (p *parser) node() *Node {
+	n := &Node{
+		Line:       p.linenumber,
+		Column:     p.column,
+		Value:      p.consume(),
+		opts:       p.opts,
+		path:       p.path,
+		keyPath:    p.keyPath,
+		prefix:     p.prefix,
+		raw:        p.raw,
+		inclPrefix: p.inclPrefix,
+		syntax:     p.lineNode,
+	}
+	if n.syntax == nil {
+		return n
+	}
+
+	// The nested lines are the descendants in the syntax tree, they are read next.
+	// Empty lines and comments are only part of the value if followed by a nested
+	// line.
+	n.lines = descendants(n.syntax)
+	for range n.lines {
+		p.readLine()
+		p.lastLine = p.linenumber
+	}
+	n.Children = n.children(n.syntax.Children, p.prefix+p.indentUnit)
+	return n
+}
+
+// descendants returns the lines nested in syntax node n, in order of the file.
+func descendants(n *ast.Node) []*ast.Node {
+	var l []*ast.Node
+	for _, c := range n.Children {
+		l = append(l, c)
+		l = append(l, descendants(c)...)
+	}
+	return l
+}
+
+// children returns the nodes for syntax nodes l, the nested lines of n with
+// indent prefix, without comments and empty lines.
+func (n *Node) children(l []*ast.Node, prefix string) []*Node {
+	var r []*Node
+	var items int
+	for _, sn := range l {
+		if sn.Kind == ast.Blank || sn.Kind == ast.Comment {
+			continue
+		}
+		c := &Node{
+			Line:       sn.Line,
+			opts:       n.opts,
+			path:       n.path,
+			prefix:     prefix,
+			raw:        n.inclPrefix + sn.Raw,
+			inclPrefix: n.inclPrefix,
+			syntax:     sn,
+			lines:      descendants(sn),
+		}
+		switch {
+		case n.inclPrefix+sn.Indent != prefix || sn.Kind == ast.Text || sn.Kind == ast.Include:
+			// Lines of multiline strings, and lines indented further than their parent.
+			c.Value = strings.TrimPrefix(c.raw, prefix)
+			c.keyPath = n.keyPath
+		case sn.Kind == ast.Item:
+			c.Item = true
+			c.Value = sn.Value
+			c.keyPath = indexPath(n.keyPath, items)
+			items++
+		default:
+			c.Key = sn.Key
+			c.Value = sn.Value
+			c.keyPath = keyPath(n.keyPath, c.Key)
+		}
+		c.Column = len(c.raw) - len(c.Value) + 1
+		c.Children = c.children(sn.Children, prefix+n.opts.indent())
+		r = append(r, c)
+	}
+	return r
+}
+
+// parseUnmarshaler parses the node at the current line with UnmarshalSconf of v.
+func (p *parser) parseUnmarshaler(v reflect.Value) {
+	n := p.node()
+	err := v.Addr().Interface().(Unmarshaler).UnmarshalSconf(n)
+	if err == nil {
+		return
+	}
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		perr = n.error(KindValue, fmt.Errorf("parsing %v: %v", v.Type(), err))
+	}
+	panic(parseError{perr})
+}
+
+// describeMarshaler writes the node returned by MarshalSconf of v.
+func (w *writer) describeMarshaler(v reflect.Value) {
+	// MarshalSconf may have a pointer receiver, so call it on an addressable copy.
+	pv := reflect.New(v.Type())
+	pv.Elem().Set(v)
+	n, err := pv.Interface().(Marshaler).MarshalSconf()
+	w.check(err)
+	if n == nil {
+		w.error(fmt.Errorf("nil node from MarshalSconf of %v", v.Type()))
+	}
+	if n.Value == "" {
+		w.write("\n")
+	} else {
+		w.write(" " + n.Value + "\n")
+	}
+	w.describeNodeChildren(n)
+}
+
+func (w *writer) describeNodeChildren(n *Node) {
+	w.indent()
+	defer w.unindent()
+	for _, c := range n.Children {
+		w.write(w.prefix)
+		var sep string
+		if c.Item {
+			w.write("-")
+			sep = " "
+		} else if c.Key != "" {
+			w.write(c.Key + ":")
+			sep = " "
+		}
+		if c.Value != "" {
+			w.write(sep + c.Value)
+		}
+		w.write("\n")
+		w.describeNodeChildren(c)
+	}
+}
@@ -0,0 +1,197 @@
+package sconf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// rules has its own syntax: lines with "allow" or "deny" and a name.
+type rules []rule
+
+type rule struct {
+	Allow bool
+	Name  string
+}
+
+func (r *rules) UnmarshalSconf(n *Node) error {
+	if n.Value != "" {
+		return n.Errorf("unexpected value %q", n.Value)
+	}
+	for _, c := range n.Children {
+		t := strings.Split(c.Value, " ")
+		if c.Key != "" || c.Item || len(t) != 2 || t[0] != "allow" && t[0] != "deny" {
+			return c.Errorf("bad rule %q", c.Value)
+		}
+		*r = append(*r, rule{t[0] == "allow", t[1]})
+	}
+	return nil
+}
+
+func (r rules) MarshalSconf() (*Node, error) {
+	n := &Node{}
+	for _, x := range r {
+		s := "deny " + x.Name
+		if x.Allow {
+			s = "allow " + x.Name
+		}
+		n.Children = append(n.Children, &Node{Value: s})
+	}
+	return n, nil
+}
+
+// shape picks its type based on its Kind key.
+type shape struct {
+	Value interface{}
+}
+
+type circle struct {
+	Kind   string
+	Radius int
+}
+
+type square struct {
+	Kind string
+	Side int
+}
+
+func (s *shape) UnmarshalSconf(n *Node) error {
+	for _, c := range n.Children {
+		if c.Key != "Kind" {
+			continue
+		}
+		switch c.Value {
+		case "circle":
+			var v circle
+			s.Value = &v
+			return n.Decode(&v)
+		case "square":
+			var v square
+			s.Value = &v
+			return n.Decode(&v)
+		}
+		return c.Errorf("unknown kind %q", c.Value)
+	}
+	return errors.New("missing kind")
+}
+
+func TestUnmarshaler(t *testing.T) {
+	type xconfig struct {
+		Rules  rules
+		Shapes []shape
+		Single rules `sconf:"optional"`
+	}
+	src := `Rules:
+	allow alice
+
+	# comment
+	deny bob
+Shapes:
+	-
+		Kind: circle
+		Radius: 1
+	-
+		Kind: square
+		Side: 2
+`
+	var config xconfig
+	if err := Parse(strings.NewReader(src), &config); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	exp := xconfig{
+		Rules:  rules{{true, "alice"}, {false, "bob"}},
+		Shapes: []shape{{&circle{"circle", 1}}, {&square{"square", 2}}},
+	}
+	if !reflect.DeepEqual(config, exp) {
+		t.Fatalf("got %#v, expected %#v", config, exp)
+	}
+
+	testErr := func(src string, line int, keyPath, exp string) {
+		t.Helper()
+		err := Parse(strings.NewReader(src), &xconfig{})
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("got %v, expected *ParseError", err)
+		}
+		if perr.Line != line || perr.KeyPath != keyPath || perr.Err.Error() != exp {
+			t.Fatalf("got %q at line %d, key %s; expected %q at line %d, key %s", perr.Err, perr.Line, perr.KeyPath, exp, line, keyPath)
+		}
+	}
+	testErr("Rules:\n\tallow x\n\tmaybe y\n", 3, "Rules", `bad rule "maybe y"`)
+	testErr("Rules: value\n", 1, "Rules", `unexpected value "value"`)
+	testErr("Rules:\nShapes:\n\t-\n\t\tKind: circle\n\t\tRadius: x\n", 5, "Shapes[0].Radius", "parsing integer: strconv.ParseInt: parsing \"x\": invalid syntax")
+	testErr("Rules:\nShapes:\n\t-\n\t\tKind: triangle\n", 4, "Shapes[0].Kind", `unknown kind "triangle"`)
+	testErr("Rules:\nShapes:\n\t-\n\t\tSide: 1\n", 3, "Shapes[0]", "parsing sconf.shape: missing kind")
+
+	// Lines after the unmarshaled value are parsed normally.
+	err := Parse(strings.NewReader("Rules:\n\tallow x\nBogus: 1\n"), &xconfig{})
+	if err == nil || err.Error() != `:3: unknown key "Bogus"` {
+		t.Fatalf("got %v, expected unknown key error", err)
+	}
+}
+
+func TestMarshaler(t *testing.T) {
+	type xconfig struct {
+		Rules rules
+		Map   map[string]rules
+		Opt   rules `sconf:"optional"`
+	}
+	config := xconfig{
+		Rules: rules{{true, "alice"}, {false, "bob"}},
+		Map:   map[string]rules{"x": {{true, "carol"}}},
+	}
+	exp := `Rules:
+	allow alice
+	deny bob
+Map:
+	x:
+		allow carol
+`
+	out := &bytes.Buffer{}
+	if err := Write(out, config); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if out.String() != exp {
+		t.Fatalf("got:\n%s\n\nexpected:\n%s", out.String(), exp)
+	}
+	var nconfig xconfig
+	if err := Parse(out, &nconfig); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !reflect.DeepEqual(nconfig, config) {
+		t.Fatalf("parse: got %#v, expected %#v", nconfig, config)
+	}
+}
+
+func TestNodeDecode(t *testing.T) {
+	n := &Node{
+		Children: []*Node{
+			{Key: "Name", Value: "test"},
+			{Key: "List", Children: []*Node{{Item: true, Value: "1"}, {Item: true, Value: "2"}}},
+		},
+	}
+	var v struct {
+		Name string
+		List []int
+	}
+	if err := n.Decode(&v); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if v.Name != "test" || fmt.Sprint(v.List) != "[1 2]" {
+		t.Fatalf("got %#v", v)
+	}
+
+	var i int
+	if err := (&Node{Value: "1", Children: []*Node{{Value: "x"}}}).Decode(&i); err == nil || err.Error() != `:1: unexpected line "\tx"` {
+		t.Fatalf("got %v, expected error for unexpected line", err)
+	}
+
+	// Children of a multiline string are its lines, whatever they look like.
+	var s string
+	if err := (&Node{Value: "|", Children: []*Node{{Value: "a: b"}, {Value: "- c"}}}).Decode(&s); err != nil || s != "a: b\n- c\n" {
+		t.Fatalf("got %q %v, expected multiline string", s, err)
+	}
+}
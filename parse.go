@@ -1,7 +1,7 @@
 package sconf
 
 import (
-	"bufio"
+	"encoding"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -10,50 +10,187 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/mjl-/sconf/ast"
 )
 
 type parser struct {
-	prefix     string        // indented string
-	input      *bufio.Reader // for reading lines at a time
-	line       string        // last read line
+	opts       DecoderOptions
+	path       string      // file name, for errors
+	prefix     string      // indented string
+	indentUnit string      // one level of indenting, from options
+	input      []*ast.Node // lines still to read, in order of the file
+	lineNode   *ast.Node   // syntax node of the last read line
+	line       string      // last read line
+	raw        string      // full text of last read line, for errors
 	linenumber int
+	lastLine   int    // last line that was consumed, for the end of a struct
+	column     int    // 1-based column in raw of the item being parsed, for errors
+	keyPath    string // path to the value being parsed, for errors
+	allErrors  bool   // whether to continue after errors, collecting them in errs
+	errs       ParseErrors
+
+	inclPrefix string        // indent of include directive, prepended to lines of included file
+	includes   []includeFile // outer files, with the file that included the current file last
+
+	merge      *merge // for ParseFiles, when parsing into values of earlier files
+	appendList bool   // whether the list being parsed is appended to, for merging
 }
 
 type parseError struct {
-	err error
+	err *ParseError
+}
+
+// fileLines returns the lines of a file, parsed by package ast, in order of the
+// file. The kind of each line, e.g. comment, include directive, key/value, list
+// item or line of a multiline string, is that of the syntax tree.
+func fileLines(buf []byte, indent string) []*ast.Node {
+	return ast.Parse(buf, indent).Lines()
+}
+
+func newParser(input []*ast.Node, opts DecoderOptions) *parser {
+	return &parser{
+		opts:       opts,
+		path:       opts.Path,
+		indentUnit: opts.indent(),
+		input:      input,
+		allErrors:  opts.AllErrors,
+	}
 }
 
-func parse(path string, src io.Reader, dst interface{}) (err error) {
-	p := &parser{
-		input: bufio.NewReader(src),
+// parse reads a config file from src into dst. If m is not nil, values are merged
+// into dst as parsed from earlier files, see ParseFiles.
+func parse(src io.Reader, dst interface{}, opts DecoderOptions, m *merge) error {
+	if err := checkIndent(opts.Indent); err != nil {
+		return err
 	}
+	buf, err := io.ReadAll(src)
+	if err != nil {
+		return &ParseError{Path: opts.Path, Kind: KindIO, Err: err}
+	}
+	p := newParser(fileLines(buf, opts.indent()), opts)
+	p.merge = m
+	return p.run(func() {
+		v := reflect.ValueOf(dst)
+		if v.Kind() != reflect.Ptr {
+			p.stop(KindType, "destination not a pointer")
+		}
+		p.parseStruct0(v.Elem(), 1)
+	})
+}
+
+// run calls fn and returns the errors it raised.
+func (p *parser) run(fn func()) (err error) {
 	defer func() {
 		x := recover()
 		if x == nil {
 			return
 		}
 		perr, ok := x.(parseError)
-		if ok {
-			err = fmt.Errorf("%s:%d: %v", path, p.linenumber, perr.err)
-			return
+		if !ok {
+			panic(x)
+		}
+		if p.allErrors {
+			err = append(p.errs, perr.err)
+		} else {
+			err = perr.err
 		}
-		panic(x)
 	}()
-	v := reflect.ValueOf(dst)
-	if v.Kind() != reflect.Ptr {
-		p.stop("destination not a pointer")
+	fn()
+	if len(p.errs) > 0 {
+		return p.errs
+	}
+	return nil
+}
+
+// error returns a ParseError for the current position.
+func (p *parser) error(kind ErrorKind, err error) *ParseError {
+	raw, column := fileLine(p.raw, p.column, p.inclPrefix)
+	return &ParseError{
+		Path:    p.path,
+		Line:    p.linenumber,
+		Column:  column,
+		KeyPath: p.keyPath,
+		RawLine: raw,
+		Kind:    kind,
+		Err:     err,
+	}
+}
+
+// fileLine returns raw and column as in the file, without the indent of an
+// include directive.
+func fileLine(raw string, column int, inclPrefix string) (string, int) {
+	if inclPrefix == "" || !strings.HasPrefix(raw, inclPrefix) {
+		return raw, column
+	}
+	if column > len(inclPrefix) {
+		column -= len(inclPrefix)
+	}
+	return raw[len(inclPrefix):], column
+}
+
+func (p *parser) stop(kind ErrorKind, err string) {
+	panic(parseError{p.error(kind, errors.New(err))})
+}
+
+// warn reports a warning about the current line through the Warn option, or
+// as error in strict mode.
+func (p *parser) warn(kind ErrorKind, msg string) {
+	if p.opts.Strict {
+		p.stop(kind, msg)
+	} else if p.opts.Warn != nil {
+		p.opts.Warn(p.error(kind, errors.New(msg)))
+	}
+}
+
+// fail records err and continues when collecting all errors, and stops otherwise.
+func (p *parser) fail(err *ParseError) {
+	if !p.allErrors {
+		panic(parseError{err})
 	}
-	p.parseStruct0(v.Elem())
-	return
+	p.errs = append(p.errs, err)
 }
 
-func (p *parser) stop(err string) {
-	panic(parseError{errors.New(err)})
+// item calls fn to parse a single key/value or list item. When collecting all
+// errors, an error is recorded and the remainder of the item, including its
+// nested lines, is skipped, so parsing continues with the next item.
+func (p *parser) item(fn func()) {
+	if !p.allErrors {
+		fn()
+		return
+	}
+	prefix := p.prefix
+	line := p.linenumber
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		perr, ok := x.(parseError)
+		if !ok || perr.err.Kind == KindIO {
+			panic(x)
+		}
+		p.errs = append(p.errs, perr.err)
+		p.prefix = prefix
+		if p.linenumber == line {
+			p.consume()
+		}
+		p.skip()
+	}()
+	fn()
+}
+
+// skip consumes lines indented deeper than the current level.
+func (p *parser) skip() {
+	deeper := p.prefix + p.indentUnit
+	for p.next() && strings.HasPrefix(p.line, deeper) {
+		p.consume()
+	}
 }
 
 func (p *parser) check(err error, action string) {
 	if err != nil {
-		p.stop(fmt.Sprintf("%s: %s", action, err))
+		p.stop(KindValue, fmt.Sprintf("%s: %s", action, err))
 	}
 }
 
@@ -63,29 +200,37 @@ func (p *parser) string() string {
 
 func (p *parser) leave(s string) {
 	p.line = s
+	if strings.HasSuffix(p.raw, s) {
+		p.column = len(p.raw) - len(s) + 1
+	}
 }
 
 func (p *parser) consume() string {
 	s := p.line
 	p.line = ""
+	p.lastLine = p.linenumber
 	return s
 }
 
 // Next returns whether the next line is properly indented, reading data as necessary.
 func (p *parser) next() bool {
-	for p.line == "" {
-		s, err := p.input.ReadString('\n')
-		if s == "" {
-			if err == io.EOF {
+	for p.line == "" || p.atInclude() {
+		if p.line != "" {
+			p.include()
+			continue
+		}
+		s, ok := p.readLine()
+		if !ok {
+			if len(p.includes) == 0 {
 				return false
 			}
-			p.stop(err.Error())
+			p.endInclude()
+			continue
 		}
-		p.linenumber++
-		if strings.HasPrefix(strings.TrimSpace(s), "#") {
+		if p.lineNode.Kind == ast.Blank || p.lineNode.Kind == ast.Comment {
 			continue
 		}
-		p.line = strings.TrimSuffix(s, "\n")
+		p.line = s
 	}
 
 	// Less indenting than expected. Let caller stop, returning to its caller for lower-level indent.
@@ -93,21 +238,74 @@ func (p *parser) next() bool {
 	return r
 }
 
+// readLine reads the next line, without newline. It returns false at the end
+// of the current file, also for included files.
+func (p *parser) readLine() (string, bool) {
+	if len(p.input) == 0 {
+		return "", false
+	}
+	n := p.input[0]
+	p.input = p.input[1:]
+	p.lineNode = n
+	p.linenumber = n.Line
+	p.raw = n.Raw
+	if n.Raw != "" {
+		p.raw = p.inclPrefix + n.Raw
+	}
+	p.column = 1
+	return p.raw, true
+}
+
+// indentOf returns the indent of syntax node n in the current file, including
+// the indent of an include directive.
+func (p *parser) indentOf(n *ast.Node) string {
+	return p.inclPrefix + n.Indent
+}
+
+// atInclude returns whether the current line is an include directive at the
+// current indent.
+func (p *parser) atInclude() bool {
+	return p.lineNode != nil && p.lineNode.Kind == ast.Include && p.line == p.raw && p.indentOf(p.lineNode) == p.prefix
+}
+
+// block reads the lines of the multiline string started at the current line,
+// the Text children of its syntax node, with their indent removed. Empty lines
+// are part of the block only if followed by another line of the block. Lines
+// in the block are not interpreted, so lines looking like comments are
+// included. If no lines follow, false is returned.
+func (p *parser) block() ([]string, bool) {
+	n := p.lineNode
+	if n == nil || !n.IsBlock() {
+		return nil, false
+	}
+	var lines []string
+	for _, c := range n.Children {
+		p.readLine()
+		lines = append(lines, c.Value)
+		p.lastLine = p.linenumber
+	}
+	return lines, len(lines) > 0
+}
+
 func (p *parser) indent() {
-	p.prefix += "\t"
+	p.prefix += p.indentUnit
 	if !p.next() {
-		p.stop("expected indent")
+		p.stop(KindSyntax, "expected indent")
 	}
 }
 
 func (p *parser) unindent() {
-	p.prefix = p.prefix[1:]
+	p.prefix = p.prefix[len(p.indentUnit):]
 }
 
 var durationType = reflect.TypeOf(time.Duration(0))
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
 
 func (p *parser) parseValue(v reflect.Value) reflect.Value {
 	t := v.Type()
+	appendList := p.appendList
+	p.appendList = false
 
 	if t == durationType {
 		s := p.consume()
@@ -116,10 +314,41 @@ func (p *parser) parseValue(v reflect.Value) reflect.Value {
 		v.Set(reflect.ValueOf(d))
 		return v
 	}
+	if t == byteSizeType {
+		s := p.consume()
+		x, err := ParseByteSize(s)
+		p.check(err, "parsing byte size")
+		v.SetInt(int64(x))
+		return v
+	}
+	if t == percentType {
+		s := p.consume()
+		x, err := ParsePercent(s)
+		p.check(err, "parsing percentage")
+		v.SetFloat(float64(x))
+		return v
+	}
+	if isStdType(t) {
+		p.parseStdType(v)
+		return v
+	}
+
+	// Pointer types are dereferenced below, after which we'll find the
+	// implementations on the pointer receiver.
+	if t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(unmarshalerType) {
+		p.parseUnmarshaler(v)
+		return v
+	}
+	if t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		s := p.parseString()
+		err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		p.check(err, fmt.Sprintf("parsing %v", t))
+		return v
+	}
 
 	switch t.Kind() {
 	default:
-		p.stop(fmt.Sprintf("cannot parse type %v", t.Kind()))
+		p.stop(KindType, fmt.Sprintf("cannot parse type %v", t.Kind()))
 
 	case reflect.Bool:
 		s := p.consume()
@@ -129,34 +358,53 @@ func (p *parser) parseValue(v reflect.Value) reflect.Value {
 		case "true":
 			v.SetBool(true)
 		default:
-			p.stop(fmt.Sprintf("bad boolean value %q", s))
+			p.stop(KindValue, fmt.Sprintf("bad boolean value %q", s))
 		}
 
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		s := p.consume()
-		x, err := strconv.ParseInt(s, 10, 64)
+		x, err := parseInt(s, t.Bits())
+		p.checkRange(err, s, t)
 		p.check(err, "parsing integer")
 		v.SetInt(x)
 
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 		s := p.consume()
-		x, err := strconv.ParseUint(s, 10, 64)
+		x, err := parseUint(s, t.Bits())
+		p.checkRange(err, s, t)
 		p.check(err, "parsing integer")
 		v.SetUint(x)
 
 	case reflect.Float32, reflect.Float64:
 		s := p.consume()
-		x, err := strconv.ParseFloat(s, 64)
+		x, err := strconv.ParseFloat(s, t.Bits())
+		p.checkRange(err, s, t)
 		p.check(err, "parsing float")
 		v.SetFloat(x)
 
 	case reflect.String:
-		v.SetString(p.consume())
+		v.SetString(p.parseString())
 
 	case reflect.Slice:
+		if p.merge != nil && !appendList {
+			// Lists of earlier files are replaced.
+			p.merge.forget(p.keyPath)
+			v.Set(reflect.Zero(t))
+		}
 		v = p.parseSlice(v)
 
+	case reflect.Array:
+		if p.merge != nil {
+			p.merge.forget(p.keyPath)
+		}
+		p.parseArray(v)
+
 	case reflect.Ptr:
+		if p.merge != nil && !v.IsNil() {
+			p.appendList = appendList
+			p.parseValue(v.Elem())
+			break
+		}
 		vv := reflect.New(t.Elem())
 		p.parseValue(vv.Elem())
 		v.Set(vv)
@@ -164,13 +412,108 @@ func (p *parser) parseValue(v reflect.Value) reflect.Value {
 	case reflect.Struct:
 		p.parseStruct(v)
 
+	case reflect.Interface:
+		if p.merge != nil {
+			p.merge.forget(p.keyPath)
+		}
+		p.parseInterface(v)
+
 	case reflect.Map:
-		v = reflect.MakeMap(t)
+		// When merging, entries are added to the map of earlier files.
+		if p.merge == nil || v.IsNil() {
+			v = reflect.MakeMap(t)
+		}
 		p.parseMap(v)
 	}
 	return v
 }
 
+// checkRange stops with a clear error if err is about number s being out of
+// range for type t.
+func (p *parser) checkRange(err error, s string, t reflect.Type) {
+	if errors.Is(err, strconv.ErrRange) {
+		p.stop(KindValue, fmt.Sprintf("value %s out of range for %v", s, t))
+	}
+}
+
+// parseInt parses s as a signed integer of bits size. Besides decimal, the Go
+// prefixes 0x, 0o and 0b are accepted, as are underscores between digits. Unlike
+// in Go, a leading zero does not make a number octal.
+func parseInt(s string, bits int) (int64, error) {
+	ds, base, err := intDigits(s, "ParseInt")
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseInt(ds, base, bits)
+	return v, numError(err, s)
+}
+
+// parseUint is like parseInt, for unsigned integers.
+func parseUint(s string, bits int) (uint64, error) {
+	ds, base, err := intDigits(s, "ParseUint")
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(ds, base, bits)
+	return v, numError(err, s)
+}
+
+// intDigits returns the text and base for strconv.ParseInt: base 0 to handle
+// prefixes and underscores, or base 10 for a leading zero followed by a digit or
+// underscore, with the underscores removed after checking they are between
+// digits.
+func intDigits(s, fn string) (string, int, error) {
+	d := strings.TrimLeft(s, "+-")
+	if len(d) < 2 || d[0] != '0' || !isDigit(d[1]) && d[1] != '_' {
+		return s, 0, nil
+	}
+	for i := 1; i < len(d); i++ {
+		if d[i] == '_' && (i == len(d)-1 || !isDigit(d[i-1]) || !isDigit(d[i+1])) {
+			return "", 0, &strconv.NumError{Func: fn, Num: s, Err: strconv.ErrSyntax}
+		}
+	}
+	return strings.ReplaceAll(s, "_", ""), 10, nil
+}
+
+// numError sets the original text s in a *strconv.NumError, for text that was
+// parsed without underscores.
+func numError(err error, s string) error {
+	if nerr, ok := err.(*strconv.NumError); ok {
+		nerr.Num = s
+	}
+	return err
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// parseString returns a string value. A value starting with a double quote is
+// a Go double-quoted string with escapes. The value "|" followed by more
+// indented lines is a multiline string, with a newline after each line. With
+// "|-", the newline after the last line is left out. Without more indented
+// lines, the value is taken literally.
+func (p *parser) parseString() string {
+	s := p.consume()
+	if strings.HasPrefix(s, `"`) {
+		r, err := strconv.Unquote(s)
+		p.check(err, "parsing quoted string")
+		return r
+	}
+	if s != "|" && s != "|-" {
+		return s
+	}
+	lines, ok := p.block()
+	if !ok {
+		return s
+	}
+	r := strings.Join(lines, "\n")
+	if s == "|" {
+		r += "\n"
+	}
+	return r
+}
+
 func (p *parser) parseSlice(v reflect.Value) reflect.Value {
 	if v.Type().Elem().Kind() == reflect.Uint8 {
 		s := p.consume()
@@ -186,145 +529,497 @@ func (p *parser) parseSlice(v reflect.Value) reflect.Value {
 }
 
 func (p *parser) parseSlice0(v reflect.Value) reflect.Value {
-	for p.next() {
-		s := p.string()
-		prefix := p.prefix + "-"
-		if !strings.HasPrefix(s, prefix) {
-			p.stop(fmt.Sprintf("expected item, prefix %q, saw %q", prefix, s))
-		}
-		s = s[len(prefix):]
-		if s != "" {
-			if !strings.HasPrefix(s, " ") {
-				p.stop("missing space after -")
-			}
-			s = s[1:]
-		}
-		p.leave(s)
-		vv := reflect.New(v.Type().Elem()).Elem()
-		vv = p.parseValue(vv)
-		v = reflect.Append(v, vv)
+	path := p.keyPath
+	// Items may be appended to those of an earlier file, see ParseFiles.
+	for i := v.Len(); p.next(); i++ {
+		p.item(func() {
+			v = p.parseItem(v, indexPath(path, i))
+		})
 	}
+	p.keyPath = path
 	return v
 }
 
+// parseArray parses a list with exactly the number of elements of array v. Byte
+// arrays are parsed as base64, like byte slices.
+func (p *parser) parseArray(v reflect.Value) {
+	t := v.Type()
+	if t.Elem().Kind() == reflect.Uint8 {
+		s := p.consume()
+		buf, err := base64.StdEncoding.DecodeString(s)
+		p.check(err, "parsing base64")
+		if len(buf) != t.Len() {
+			p.stop(KindValue, fmt.Sprintf("got %d bytes, expected %d", len(buf), t.Len()))
+		}
+		reflect.Copy(v, reflect.ValueOf(buf))
+		return
+	}
+
+	start := p.linenumber
+	p.indent()
+	defer p.unindent()
+	path := p.keyPath
+	st := reflect.SliceOf(t.Elem())
+	n := 0
+	for i := 0; p.next(); i++ {
+		p.item(func() {
+			if i >= t.Len() {
+				p.keyPath = indexPath(path, i)
+				p.column = len(p.prefix) + 1
+				p.stop(KindValue, fmt.Sprintf("too many elements, array has length %d", t.Len()))
+			}
+			l := p.parseItem(reflect.MakeSlice(st, 0, 1), indexPath(path, i))
+			v.Index(i).Set(l.Index(0))
+		})
+		n = i + 1
+	}
+	p.keyPath = path
+	if n < t.Len() {
+		perr := p.error(KindValue, fmt.Errorf("too few elements, got %d, array has length %d", n, t.Len()))
+		perr.Line = start
+		perr.Column = 0
+		perr.RawLine = ""
+		p.fail(perr)
+	}
+}
+
+// parseItem parses a list item at the current line and appends it to v.
+func (p *parser) parseItem(v reflect.Value, path string) reflect.Value {
+	p.keyPath = path
+	p.column = len(p.prefix) + 1
+	s := p.string()
+	prefix := p.prefix + "-"
+	n := p.lineNode
+	if !strings.HasPrefix(s, prefix) || p.indentOf(n) != p.prefix {
+		p.stop(KindSyntax, fmt.Sprintf("expected item, prefix %q, saw %q", prefix, s))
+	} else if n.Kind != ast.Item {
+		p.stop(KindSyntax, "missing space after -")
+	}
+	p.leave(n.Value)
+	vv := reflect.New(v.Type().Elem()).Elem()
+	vv = p.parseValue(vv)
+	return reflect.Append(v, vv)
+}
+
 func (p *parser) parseStruct(v reflect.Value) {
+	start := p.linenumber
 	p.indent()
 	defer p.unindent()
-	p.parseStruct0(v)
+	p.parseStruct0(v, start)
 }
 
-func (p *parser) parseStruct0(v reflect.Value) {
+// parseStruct0 parses the fields of struct v, which starts at line start.
+func (p *parser) parseStruct0(v reflect.Value, start int) {
+	path := p.keyPath
+	nerrs := len(p.errs)
 	seen := map[string]struct{}{}
-	var zeroValue reflect.Value
-	t := v.Type()
 	for p.next() {
-		origs := p.string()
-		s := origs[len(p.prefix):]
-		l := strings.SplitN(s, ":", 2)
-		if len(l) != 2 {
-			var more string
-			if strings.TrimSpace(s) == "" {
-				more = " (perhaps stray whitespace)"
-			} else if strings.HasPrefix(l[0], " ") {
-				more = " (perhaps mixed tab/space indenting)"
-			}
-			p.stop(fmt.Sprintf("missing colon for struct key/value on non-empty line %q%s", origs, more))
+		p.item(func() {
+			p.parseField(v, path, seen)
+		})
+	}
+	p.keyPath = path
+
+	p.missingFields(v, path, func(f field) bool {
+		_, ok := seen[f.key]
+		// When merging, missing keys are checked on the result.
+		return ok || p.merge != nil
+	})
+
+	// Only validate structs that were parsed without errors.
+	if len(p.errs) > nerrs || p.merge != nil {
+		return
+	}
+	p.validateStruct(v, path, start)
+}
+
+// missingFields checks the tags of the fields of struct v, and sets defaults or
+// fails for fields that are not present.
+func (p *parser) missingFields(v reflect.Value, path string, present func(f field) bool) {
+	for _, f := range p.structFields(v.Type()) {
+		// Defaults are checked for each struct, not only when needed, so mistakes are found early.
+		def, hasDefault, err := fieldDefault(f.StructField)
+		if err != nil {
+			p.fail(p.fieldError(KindTag, path, f, err))
+			continue
 		}
-		k := l[0]
-		if k == "" {
-			p.stop("empty key in struct")
-		} else if strings.HasPrefix(k, " ") {
-			p.stop("key in struct starting with space (perhaps mixed tab/space indenting)")
+		if present(f) {
+			continue
 		}
-		if _, ok := seen[k]; ok {
-			p.stop("duplicate key in struct")
+		constraints, err := fieldConstraints(f.StructField)
+		if err == nil && hasDefault {
+			if err = validate(constraints, def); err != nil {
+				err = fmt.Errorf("sconf-default tag of field %s: %v", f.Name, err)
+			}
 		}
-		seen[k] = struct{}{}
-		s = l[1]
-		if s != "" && !strings.HasPrefix(s, " ") {
-			p.stop("missing space after colon in struct")
+		if err != nil {
+			p.fail(p.fieldError(KindTag, path, f, err))
+			continue
 		}
-		if s != "" {
-			s = s[1:]
+		if isOptional(f.Tag.Get("sconf")) {
+			if hasDefault {
+				fieldValue(v, f, true).Set(def)
+			}
+			continue
 		}
-		p.leave(s)
+		p.fail(p.fieldError(KindMissingKey, path, f, fmt.Errorf("missing required key %q", f.key)))
+	}
+}
+
+// validateStruct calls Validate on struct v, starting at line start, if it
+// implements Validator.
+func (p *parser) validateStruct(v reflect.Value, path string, start int) {
+	var validator Validator
+	if v.CanAddr() {
+		validator, _ = v.Addr().Interface().(Validator)
+	} else {
+		validator, _ = v.Interface().(Validator)
+	}
+	if validator == nil {
+		return
+	}
+	if err := validator.Validate(); err != nil {
+		perr := p.error(KindValidation, err)
+		perr.KeyPath = path
+		perr.Line = start
+		perr.EndLine = p.lastLine
+		if perr.EndLine < start {
+			perr.EndLine = start
+		}
+		perr.Column = 0
+		perr.RawLine = ""
+		p.fail(perr)
+	}
+}
 
-		vv := v.FieldByName(k)
-		if vv == zeroValue {
-			var more string
-			if strings.TrimSpace(k) != k {
-				more = " (perhaps stray whitespace in key)"
+// Validator is implemented by types that check their value after parsing, for
+// rules that cannot be expressed in struct tags, such as conditions involving
+// multiple fields. Validate is called for structs after all their fields have
+// been parsed. An error is returned as *ParseError with the lines of the struct.
+type Validator interface {
+	Validate() error
+}
+
+// fieldError returns an error about field f of the struct at path, not about a
+// line in the file.
+func (p *parser) fieldError(kind ErrorKind, path string, f field, err error) *ParseError {
+	perr := p.error(kind, err)
+	perr.KeyPath = keyPath(path, f.key)
+	perr.Column = 0
+	perr.RawLine = ""
+	return perr
+}
+
+// structFields returns the fields of struct type t, stopping on errors.
+func (p *parser) structFields(t reflect.Type) []field {
+	fields, err := structFields(t)
+	if _, ok := err.(tagError); ok {
+		p.stop(KindTag, err.Error())
+	} else if err != nil {
+		p.stop(KindType, err.Error())
+	}
+	return fields
+}
+
+// fieldDefault returns the value of the "sconf-default" tag of f, parsed like
+// a value in a config file.
+func fieldDefault(f reflect.StructField) (v reflect.Value, ok bool, err error) {
+	s, ok := f.Tag.Lookup("sconf-default")
+	if !ok {
+		return reflect.Value{}, false, nil
+	}
+	if !isOptional(f.Tag.Get("sconf")) {
+		return reflect.Value{}, false, fmt.Errorf("sconf-default tag on required field %s", f.Name)
+	}
+
+	v, err = parseTagValue(f.Type, s)
+	if err != nil {
+		return reflect.Value{}, false, fmt.Errorf("parsing sconf-default tag of field %s: %v", f.Name, err)
+	}
+	return v, true, nil
+}
+
+// parseTagValue parses s from a struct tag as a value of type t.
+func parseTagValue(t reflect.Type, s string) (reflect.Value, error) {
+	p := newParser(nil, DecoderOptions{})
+	v := reflect.New(t).Elem()
+	err := p.run(func() {
+		// The value is not a line of a file, it has no key, list item or nested lines.
+		p.lineNode = &ast.Node{Kind: ast.Text, Value: s, Raw: s}
+		p.line = s
+		v = p.parseValue(v)
+	})
+	if perr, ok := err.(*ParseError); ok {
+		return v, perr.Err
+	}
+	return v, err
+}
+
+// parseField parses a key/value at the current line into a field of struct v.
+func (p *parser) parseField(v reflect.Value, path string, seen map[string]struct{}) {
+	t := v.Type()
+
+	p.keyPath = path
+	p.column = len(p.prefix) + 1
+	origs := p.string()
+	k, s := p.keyValue("struct")
+	if k == "" {
+		p.stop(KindSyntax, "empty key in struct")
+	} else if strings.HasPrefix(k, " ") {
+		p.stop(KindSyntax, "key in struct starting with space (perhaps mixed tab/space indenting)")
+	}
+	p.keyPath = keyPath(path, k)
+	var ft field
+	var found bool
+	for _, f := range p.structFields(t) {
+		if f.key == k {
+			ft = f
+			found = true
+			break
+		}
+		for _, a := range f.aliases {
+			if a == k {
+				p.warn(KindDeprecated, fmt.Sprintf("key %q is deprecated, use %q", k, f.key))
+				ft = f
+				found = true
+				break
 			}
-			p.stop(fmt.Sprintf("unknown key %q%s", k, more))
 		}
-		if ft, _ := t.FieldByName(k); !ft.IsExported() || isIgnore(ft.Tag.Get("sconf")) {
-			p.stop(fmt.Sprintf("unknown key %q (has ignore tag or not exported)", k))
+		if found {
+			break
 		}
-		vv.Set(p.parseValue(vv))
 	}
-
-	n := t.NumField()
-	for i := 0; i < n; i++ {
-		f := t.Field(i)
-		if !f.IsExported() || isIgnore(f.Tag.Get("sconf")) || isOptional(f.Tag.Get("sconf")) {
-			continue
+	if !found {
+		var more string
+		if f, ok := t.FieldByName(k); ok {
+			if name := tagValues(f.Tag.Get("sconf"), "name"); len(name) == 1 {
+				more = fmt.Sprintf(" (field has key %q)", name[0])
+			} else {
+				more = " (has ignore tag or not exported)"
+			}
+		} else if strings.TrimSpace(k) != k {
+			more = " (perhaps stray whitespace in key)"
 		}
-		if _, ok := seen[f.Name]; !ok {
-			p.stop(fmt.Sprintf("missing required key %q", f.Name))
+		msg := fmt.Sprintf("unknown key %q%s", k, more)
+		if !p.opts.IgnoreUnknownKeys {
+			p.stop(KindUnknownKey, msg)
 		}
+		p.warn(KindUnknownKey, msg)
+		p.consume()
+		p.skip()
+		return
+	}
+	// Aliases are the same key as the canonical key.
+	if _, ok := seen[ft.key]; ok {
+		p.stop(KindDuplicateKey, "duplicate key in struct")
+	}
+	seen[ft.key] = struct{}{}
+	if p.merge != nil {
+		// The same key for an alias, for checking the merged result.
+		p.keyPath = keyPath(path, ft.key)
+		p.merge.seen[p.keyPath] = true
+	}
+	if isDeprecated(ft.Tag.Get("sconf")) {
+		msg := fmt.Sprintf("key %q is deprecated", ft.key)
+		if more := ft.Tag.Get("sconf-deprecated"); more != "" {
+			msg += ": " + more
+		}
+		p.warn(KindDeprecated, msg)
+	}
+	if s != "" && !strings.HasPrefix(s, " ") {
+		p.column = len(origs) - len(s) + 1
+		p.stop(KindSyntax, "missing space after colon in struct")
+	}
+	if s != "" {
+		s = s[1:]
+	}
+	vv := fieldValue(v, ft, true)
+	constraints, err := fieldConstraints(ft.StructField)
+	if err != nil {
+		p.stop(KindTag, err.Error())
+	}
+	p.leave(s)
+	// Validation errors are reported at the start of the value.
+	verr := p.error(KindValidation, nil)
+	p.appendList = hasTagWord(ft.Tag.Get("sconf"), "append")
+	vv.Set(p.parseValue(vv))
+	if err := validate(constraints, vv); err != nil {
+		verr.Err = err
+		p.fail(verr)
 	}
 }
 
 func (p *parser) parseMap(v reflect.Value) {
+	if kt := v.Type().Key(); !isMapKey(kt) {
+		p.stop(KindType, fmt.Sprintf("unsupported map key type %v", kt))
+	}
 	p.indent()
 	defer p.unindent()
 	p.parseMap0(v)
 }
 
 func (p *parser) parseMap0(v reflect.Value) {
+	path := p.keyPath
 	seen := map[string]struct{}{}
-	t := v.Type()
-	for p.next() {
-		origs := p.string()
-		s := origs[len(p.prefix):]
-		l := strings.SplitN(s, ":", 2)
-		if len(l) != 2 {
-			var more string
-			if strings.TrimSpace(s) == "" {
-				more = " (perhaps stray whitespace)"
-			} else if strings.HasPrefix(l[0], " ") {
-				more = " (perhaps mixed tab/space indenting)"
-			}
-			p.stop(fmt.Sprintf("missing colon for map key/value on non-empty line %q%s", origs, more))
+	// Keys from earlier files, that can be set once more when merging.
+	earlier := map[interface{}]bool{}
+	if p.merge != nil {
+		for _, k := range v.MapKeys() {
+			earlier[k.Interface()] = true
 		}
-		k := l[0]
-		if k == "" {
-			p.stop("empty key in map")
+	}
+	for p.next() {
+		p.item(func() {
+			p.parseEntry(v, path, seen, earlier)
+		})
+	}
+	p.keyPath = path
+}
+
+// parseEntry parses a key/value at the current line into map v.
+func (p *parser) parseEntry(v reflect.Value, path string, seen map[string]struct{}, earlier map[interface{}]bool) {
+	t := v.Type()
+
+	p.keyPath = path
+	p.column = len(p.prefix) + 1
+	origs := p.string()
+	k, s := p.keyValue("map")
+	if k == "" {
+		p.stop(KindSyntax, "empty key in map")
+	}
+	p.keyPath = keyPath(path, k)
+	if _, ok := seen[k]; ok {
+		p.stop(KindDuplicateKey, "duplicate key in map")
+	}
+	seen[k] = struct{}{}
+	kv, err := parseMapKey(t.Key(), k)
+	if err != nil {
+		p.stop(KindValue, fmt.Sprintf("parsing map key %q: %v", k, err))
+	}
+	// Different text can be the same key, e.g. "1" and "01".
+	if v.MapIndex(kv).IsValid() && !earlier[kv.Interface()] {
+		p.stop(KindDuplicateKey, "duplicate key in map")
+	}
+	delete(earlier, kv.Interface())
+	if p.merge != nil {
+		// The same key in each file, for checking the merged result.
+		p.keyPath = keyPath(path, mergeKey(kv, k))
+	}
+	if s != "" && !strings.HasPrefix(s, " ") {
+		var more string
+		if strings.HasPrefix(k, " ") {
+			more = " (key starts with space, perhaps mixed tab/space indenting)"
 		}
-		if _, ok := seen[k]; ok {
-			p.stop("duplicate key in map")
+		p.column = len(origs) - len(s) + 1
+		p.stop(KindSyntax, "missing space after colon in map"+more)
+	}
+	if s != "" {
+		s = s[1:]
+	}
+
+	vv := reflect.New(t.Elem()).Elem()
+	if p.merge != nil && v.MapIndex(kv).IsValid() {
+		// Entry of an earlier file is merged into.
+		vv.Set(v.MapIndex(kv))
+	}
+	if s == "nil" {
+		// Special value "nil" means the zero value, no further parsing of a value.
+		p.leave("")
+		vv.Set(reflect.Zero(t.Elem()))
+		if p.merge != nil {
+			p.merge.forget(p.keyPath)
+			p.merge.nils[p.keyPath] = true
 		}
-		seen[k] = struct{}{}
-		s = l[1]
-		if s != "" && !strings.HasPrefix(s, " ") {
-			var more string
-			if strings.HasPrefix(k, " ") {
-				more = " (key starts with space, perhaps mixed tab/space indenting)"
-			}
-			p.stop("missing space after colon in map" + more)
+	} else {
+		if p.merge != nil {
+			delete(p.merge.nils, p.keyPath)
 		}
-		if s != "" {
-			s = s[1:]
+		p.leave(s)
+		vv = p.parseValue(vv)
+	}
+	v.SetMapIndex(kv, vv)
+}
+
+// keyValue returns the key and the text after the colon of the current line, a
+// key/value in a struct or map, as parsed by package ast. It stops if the line
+// is not a key/value at the current indent.
+func (p *parser) keyValue(what string) (string, string) {
+	n := p.lineNode
+	origs := p.string()
+	if n.Kind == ast.Item && p.indentOf(n) == p.prefix {
+		p.stop(KindSyntax, fmt.Sprintf("unexpected list item in %s", what))
+	} else if n.Kind != ast.KeyValue {
+		s := origs[len(p.prefix):]
+		var more string
+		if strings.TrimSpace(s) == "" {
+			more = " (perhaps stray whitespace)"
+		} else if strings.HasPrefix(s, " ") {
+			more = " (perhaps mixed tab/space indenting)"
 		}
+		p.stop(KindSyntax, fmt.Sprintf("missing colon for %s key/value on non-empty line %q%s", what, origs, more))
+	}
+	if p.indentOf(n) != p.prefix {
+		p.stop(KindSyntax, fmt.Sprintf("key in %s indented too deep", what))
+	}
+	return n.Key, n.Raw[len(n.Indent)+len(n.Key)+1:]
+}
 
-		vv := reflect.New(t.Elem()).Elem()
-		if s == "nil" {
-			// Special value "nil" means the zero value, no further parsing of a value.
-			p.leave("")
-		} else {
-			p.leave(s)
-			vv = p.parseValue(vv)
+// isMapKey returns whether t can be parsed by parseMapKey.
+func isMapKey(t reflect.Type) bool {
+	if t == durationType || t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// parseMapKey parses map key k as type t. Keys can be strings, numbers, bools,
+// durations and types implementing encoding.TextUnmarshaler.
+func parseMapKey(t reflect.Type, k string) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	if t == durationType {
+		d, err := time.ParseDuration(k)
+		v.SetInt(int64(d))
+		return v, err
+	}
+	if t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(k))
+		return v, err
+	}
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(k)
+	case reflect.Bool:
+		switch k {
+		case "false":
+		case "true":
+			v.SetBool(true)
+		default:
+			return v, fmt.Errorf("bad boolean value")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := parseInt(k, t.Bits())
+		if err != nil {
+			return v, err
 		}
-		v.SetMapIndex(reflect.ValueOf(k), vv)
+		v.SetInt(x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		x, err := parseUint(k, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetUint(x)
+	case reflect.Float32, reflect.Float64:
+		x, err := strconv.ParseFloat(k, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetFloat(x)
+	default:
+		return v, fmt.Errorf("unsupported map key type %v", t)
 	}
+	return v, nil
 }
@@ -1,8 +1,11 @@
 package sconf
 
 import (
+	"bytes"
+	"errors"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -148,3 +151,439 @@ func TestParse(t *testing.T) {
 		t.Errorf("got nil, expected error parsing into non-pointer")
 	}
 }
+
+func TestParseError(t *testing.T) {
+	test := func(src string, exp ParseError) {
+		t.Helper()
+		err := Parse(strings.NewReader(src), &config1{})
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("got error %v, expected *ParseError", err)
+		}
+		if perr.Path != exp.Path || perr.Line != exp.Line || perr.Column != exp.Column || perr.KeyPath != exp.KeyPath || perr.RawLine != exp.RawLine || perr.Kind != exp.Kind {
+			t.Fatalf("got %#v, expected %#v", perr, &exp)
+		}
+	}
+
+	test("Bool: int\n", ParseError{Line: 1, Column: 7, KeyPath: "Bool", RawLine: "Bool: int", Kind: KindValue})
+	test("Bool: true\nStruct:\n\tStructList:\n\t\t-\n\t\t\tInt: 1\n\t\t\tString: s\n\t\t-\n\t\t\tInt: x\n", ParseError{Line: 8, Column: 9, KeyPath: "Struct.StructList[1].Int", RawLine: "\t\t\tInt: x", Kind: KindValue})
+	test("Map:\n\tk:word\n", ParseError{Line: 2, Column: 4, KeyPath: "Map.k", RawLine: "\tk:word", Kind: KindSyntax})
+	test("Bool: true\nBool: true\n", ParseError{Line: 2, Column: 1, KeyPath: "Bool", RawLine: "Bool: true", Kind: KindDuplicateKey})
+	test("Bogus: 1\n", ParseError{Line: 1, Column: 1, KeyPath: "Bogus", RawLine: "Bogus: 1", Kind: KindUnknownKey})
+	test("Bool: true\n", ParseError{Line: 1, KeyPath: "Int8", Kind: KindMissingKey})
+}
+
+func TestParseSyntax(t *testing.T) {
+	type xconfig struct {
+		Map  map[string]string
+		Text string
+	}
+	test := func(src, exp string) {
+		t.Helper()
+		err := Parse(strings.NewReader(src), &xconfig{})
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Kind != KindSyntax || perr.Error() != exp {
+			t.Fatalf("got %v, expected syntax error %q", err, exp)
+		}
+	}
+	// Lines have the kind of package ast, a line starting with "- " is a list item.
+	test("Map:\n\t- x: y\nText: t\n", ":2: unexpected list item in map")
+	test("Map:\n\tx: y\n- Text: t\n", ":3: unexpected list item in struct")
+	test("Map:\n\tx: y\n\t\tz: y\nText: t\n", ":3: key in map indented too deep")
+
+	// Lines of a multiline string are not interpreted.
+	var c xconfig
+	if err := Parse(strings.NewReader("Map:\n\tx: |\n\t\t- a\n\t\tinclude b\n\n\t\t# c\nText: |-\n\tt\n"), &c); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if c.Map["x"] != "- a\ninclude b\n\n# c\n" || c.Text != "t" {
+		t.Fatalf("got %#v", c)
+	}
+}
+
+func TestParseAllErrors(t *testing.T) {
+	var config struct {
+		Int    int
+		Bool   bool
+		Struct struct {
+			A int
+			B string
+		}
+		List []int
+		Name string
+		Word string
+	}
+	src := `Int: x
+Bogus:
+	Nested: 1
+	- item
+Struct:
+	A: y
+	B: ok
+List:
+	- 1
+	- z
+	- 3
+Name:nospace
+`
+	err := NewDecoder(strings.NewReader(src), DecoderOptions{Path: "test.conf", AllErrors: true}).Decode(&config)
+	var errs ParseErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got %v, expected ParseErrors", err)
+	}
+	type exp struct {
+		line    int
+		keyPath string
+		kind    ErrorKind
+	}
+	exps := []exp{
+		{1, "Int", KindValue},
+		{2, "Bogus", KindUnknownKey},
+		{6, "Struct.A", KindValue},
+		{10, "List[1]", KindValue},
+		{12, "Name", KindSyntax},
+		{12, "Bool", KindMissingKey},
+		{12, "Word", KindMissingKey},
+	}
+	if len(errs) != len(exps) {
+		t.Fatalf("got %d errors, expected %d:\n%v", len(errs), len(exps), err)
+	}
+	for i, x := range exps {
+		e := errs[i]
+		if e.Path != "test.conf" || e.Line != x.line || e.KeyPath != x.keyPath || e.Kind != x.kind {
+			t.Errorf("error %d: got %s (key %s, kind %s), expected line %d, key %s, kind %s", i, e, e.KeyPath, e.Kind, x.line, x.keyPath, x.kind)
+		}
+	}
+	if config.Struct.B != "ok" || !reflect.DeepEqual(config.List, []int{1, 3}) {
+		t.Errorf("parsing did not continue after errors, got %#v", config)
+	}
+
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr != errs[0] {
+		t.Errorf("errors.As for *ParseError did not return first error")
+	}
+}
+
+func TestKeyNames(t *testing.T) {
+	type xconfig struct {
+		Listen  string `sconf:"name=ListenAddr,alias=Addr,alias=Address"`
+		Timeout int    `sconf:"optional,name=TimeoutSecs"`
+	}
+
+	var warnings []*ParseError
+	opts := DecoderOptions{
+		Path: "test.conf",
+		Warn: func(w *ParseError) {
+			warnings = append(warnings, w)
+		},
+	}
+	decode := func(src string) (xconfig, error) {
+		warnings = nil
+		var config xconfig
+		err := NewDecoder(strings.NewReader(src), opts).Decode(&config)
+		return config, err
+	}
+
+	config, err := decode("ListenAddr: :80\nTimeoutSecs: 5\n")
+	if err != nil || config != (xconfig{":80", 5}) || len(warnings) != 0 {
+		t.Fatalf("got %#v, %v, %v, expected config without warnings", config, err, warnings)
+	}
+
+	config, err = decode("TimeoutSecs: 5\nAddress: :80\n")
+	if err != nil || config != (xconfig{":80", 5}) {
+		t.Fatalf("got %#v, %v, expected config", config, err)
+	}
+	if len(warnings) != 1 || warnings[0].Kind != KindDeprecated || warnings[0].Line != 2 || warnings[0].Column != 1 || warnings[0].Error() != `test.conf:2: key "Address" is deprecated, use "ListenAddr"` {
+		t.Fatalf("got warnings %v, expected deprecation warning for line 2", warnings)
+	}
+
+	test := func(src string, kind ErrorKind, exp string) {
+		t.Helper()
+		_, err := decode(src)
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Kind != kind || perr.Err.Error() != exp {
+			t.Fatalf("got %v, expected %s error %q", err, kind, exp)
+		}
+	}
+	test("Listen: :80\n", KindUnknownKey, `unknown key "Listen" (field has key "ListenAddr")`)
+	test("Addr: :80\nListenAddr: :80\n", KindDuplicateKey, "duplicate key in struct")
+	test("TimeoutSecs: 1\n", KindMissingKey, `missing required key "ListenAddr"`)
+
+	out := &bytes.Buffer{}
+	if err := Write(out, xconfig{":80", 5}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if exp := "ListenAddr: :80\nTimeoutSecs: 5\n"; out.String() != exp {
+		t.Fatalf("got %q, expected %q", out.String(), exp)
+	}
+
+	testTag := func(v interface{}, exp string) {
+		t.Helper()
+		err := Parse(strings.NewReader("X: 1\n"), v)
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Kind != KindTag || !strings.Contains(perr.Err.Error(), exp) {
+			t.Fatalf("got %v, expected tag error %q", err, exp)
+		}
+	}
+	testTag(&struct {
+		X int
+		Y int `sconf:"name=X"`
+	}{}, `key "X" of field Y already used by field X`)
+	testTag(&struct {
+		X int `sconf:"name=a:b"`
+	}{}, `sconf tag of field X: invalid key "a:b"`)
+	testTag(&struct {
+		X int `sconf:"name="`
+	}{}, "sconf tag of field X: empty key")
+}
+
+func TestDeprecated(t *testing.T) {
+	type xconfig struct {
+		Name    string
+		OldPort int  `sconf:"deprecated" sconf-deprecated:"use Listeners instead"`
+		Legacy  bool `sconf:"deprecated" sconf-doc:"Legacy mode."`
+	}
+
+	const src = `Name: x
+OldPort: 25
+`
+	var warnings []*ParseError
+	opts := DecoderOptions{
+		Warn: func(w *ParseError) {
+			warnings = append(warnings, w)
+		},
+	}
+	var config xconfig
+	if err := NewDecoder(strings.NewReader(src), opts).Decode(&config); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if config.OldPort != 25 {
+		t.Fatalf("got %#v, expected deprecated field to be set", config)
+	}
+	if len(warnings) != 1 || warnings[0].Kind != KindDeprecated || warnings[0].Line != 2 || warnings[0].KeyPath != "OldPort" || warnings[0].Err.Error() != `key "OldPort" is deprecated: use Listeners instead` {
+		t.Fatalf("got warnings %v, expected deprecation warning for line 2", warnings)
+	}
+
+	opts.Strict = true
+	err := NewDecoder(strings.NewReader(src), opts).Decode(&xconfig{})
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Kind != KindDeprecated || perr.Line != 2 {
+		t.Fatalf("got %v, expected deprecation error in strict mode", err)
+	}
+
+	// Describe leaves deprecated fields out, WriteDocs marks them.
+	out := &bytes.Buffer{}
+	if err := Describe(out, config); err != nil {
+		t.Fatalf("describe: %v", err)
+	}
+	if exp := "Name: x\n"; out.String() != exp {
+		t.Fatalf("got %q, expected %q", out.String(), exp)
+	}
+	// An encoder with KeepZero does write them.
+	out = &bytes.Buffer{}
+	if err := NewEncoder(out, EncoderOptions{KeepZero: true}).Encode(config); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if exp := "Name: x\nOldPort: 25\nLegacy: false\n"; out.String() != exp {
+		t.Fatalf("got %q, expected %q", out.String(), exp)
+	}
+	out = &bytes.Buffer{}
+	if err := WriteDocs(out, config); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	exp := `Name: x
+
+# (deprecated: use Listeners instead)
+OldPort: 25
+`
+	if out.String() != exp {
+		t.Fatalf("got:\n%s\n\nexpected:\n%s", out.String(), exp)
+	}
+}
+
+func TestInclude(t *testing.T) {
+	type xconfig struct {
+		Name     string
+		Port     int
+		Accounts map[string]struct {
+			Quota int
+			Notes string
+		}
+		List []string
+	}
+	var config xconfig
+	if err := ParseFile("testdata/include/main.conf", &config); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if config.Name != "main" || config.Port != 25 || config.Accounts["bob"].Notes != "from notes" || config.Accounts["alice"].Notes != "include this is text\n" || strings.Join(config.List, ",") != "a,b,c,d" {
+		t.Fatalf("got %#v", config)
+	}
+
+	test := func(path, exp string, line int, raw string, column int) {
+		t.Helper()
+		err := ParseFile(path, &xconfig{})
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("got %v, expected *ParseError", err)
+		}
+		if perr.Error() != exp || perr.Line != line || perr.RawLine != raw || perr.Column != column {
+			t.Fatalf("got %q, line %d, raw %q, column %d; expected %q, line %d, raw %q, column %d", perr, perr.Line, perr.RawLine, perr.Column, exp, line, raw, column)
+		}
+	}
+	test("testdata/include/bad.conf", "testdata/include/accounts/bad.conf:2: parsing integer: strconv.ParseInt: parsing \"x\": invalid syntax", 2, "\tQuota: x", 9)
+	test("testdata/include/cycle1.conf", "testdata/include/cycle2.conf:1: include cycle, testdata/include/cycle1.conf is already being read", 1, "include cycle1.conf", 9)
+	test("testdata/include/nofile.conf", "testdata/include/nofile.conf:2: include: open testdata/include/missing.conf: no such file or directory", 2, "include missing.conf", 9)
+
+	// Input from a reader cannot include files, unless enabled.
+	err := Parse(strings.NewReader("Name: x\ninclude testdata/include/common.conf\n"), &xconfig{})
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Kind != KindInclude || perr.Line != 2 || perr.RawLine != "include testdata/include/common.conf" {
+		t.Fatalf("got %#v, expected include error", err)
+	}
+	// With AllErrors, parsing continues after the include.
+	err = NewDecoder(strings.NewReader("include testdata/include/common.conf\nName: x\nPort: y\n"), DecoderOptions{AllErrors: true}).Decode(&xconfig{})
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 4 || errs[0].Kind != KindInclude || errs[1].Line != 3 || errs[1].Kind != KindValue {
+		t.Fatalf("got %v, expected include error and errors of later lines", err)
+	}
+	src, err := os.Open("testdata/include/main.conf")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer src.Close()
+	config = xconfig{}
+	if err := NewDecoder(src, DecoderOptions{Path: "testdata/include/main.conf", Includes: true}).Decode(&config); err != nil || config.Accounts["bob"].Notes != "from notes" {
+		t.Fatalf("decode with includes: got %v, %#v", err, config)
+	}
+
+	// Lines that look like a key/value are map entries, not includes.
+	var m struct{ M map[string]string }
+	if err := Parse(strings.NewReader("M:\n\tinclude x: y\n"), &m); err != nil || m.M["include x"] != "y" {
+		t.Fatalf("parse map with include key: got %v, %#v", err, m)
+	}
+	// But they are not written, they are too easily mistaken for an include.
+	if err := Write(&bytes.Buffer{}, m); err == nil || !strings.Contains(err.Error(), "cannot be written") {
+		t.Fatalf("write map with include key: got %v, expected error", err)
+	}
+	var tagged struct {
+		X string `sconf:"name=include x"`
+	}
+	if err := Parse(strings.NewReader("X: y\n"), &tagged); err == nil {
+		t.Fatalf("parse with include key name: expected error")
+	}
+}
+
+func TestIntegers(t *testing.T) {
+	type xconfig struct {
+		Int8   int8
+		Uint16 uint16
+		Hex    uint32   `sconf:"hex"`
+		Octal  int      `sconf:"octal"`
+		Binary []uint16 `sconf:"binary"`
+		Float  float32
+	}
+
+	const src = `Int8: -0x80
+Uint16: 1_000
+Hex: 0xdead_beef
+Octal: 0o755
+Binary:
+	- 0b101
+	- 7
+Float: 1.5
+`
+	var config xconfig
+	if err := Parse(strings.NewReader(src), &config); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	exp := xconfig{-128, 1000, 0xdeadbeef, 0755, []uint16{5, 7}, 1.5}
+	if !reflect.DeepEqual(config, exp) {
+		t.Fatalf("got %#v, expected %#v", config, exp)
+	}
+
+	// Leading zeros do not make a number octal.
+	if err := Parse(strings.NewReader(strings.Replace(src, "Uint16: 1_000", "Uint16: 010", 1)), &config); err != nil {
+		t.Fatalf("parse: %v", err)
+	} else if config.Uint16 != 10 {
+		t.Fatalf("got %d, expected 10", config.Uint16)
+	}
+	if err := Parse(strings.NewReader(strings.Replace(src, "Uint16: 1_000", "Uint16: 0_17", 1)), &config); err != nil {
+		t.Fatalf("parse: %v", err)
+	} else if config.Uint16 != 17 {
+		t.Fatalf("got %d, expected 17", config.Uint16)
+	}
+
+	out := &bytes.Buffer{}
+	if err := Write(out, exp); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	expOut := `Int8: -128
+Uint16: 1000
+Hex: 0xdeadbeef
+Octal: 0o755
+Binary:
+	- 0b101
+	- 0b111
+Float: 1.500000
+`
+	if out.String() != expOut {
+		t.Fatalf("got:\n%s\n\nexpected:\n%s", out.String(), expOut)
+	}
+
+	test := func(old, new string, exp string) {
+		t.Helper()
+		err := Parse(strings.NewReader(strings.Replace(src, old, new, 1)), &xconfig{})
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Kind != KindValue || perr.Err.Error() != exp {
+			t.Fatalf("got %v, expected %q", err, exp)
+		}
+	}
+	test("Int8: -0x80", "Int8: 300", "value 300 out of range for int8")
+	test("Uint16: 1_000", "Uint16: -1", `parsing integer: strconv.ParseUint: parsing "-1": invalid syntax`)
+	test("Uint16: 1_000", "Uint16: 0x1_0000", "value 0x1_0000 out of range for uint16")
+	test("Uint16: 1_000", "Uint16: 0_", `parsing integer: strconv.ParseUint: parsing "0_": invalid syntax`)
+	test("Uint16: 1_000", "Uint16: 01__0", `parsing integer: strconv.ParseUint: parsing "01__0": invalid syntax`)
+	test("Uint16: 1_000", "Uint16: 07_0000", "value 07_0000 out of range for uint16")
+	test("- 0b101", "- 0b102", `parsing integer: strconv.ParseUint: parsing "0b102": invalid syntax`)
+	test("Float: 1.5", "Float: 1e39", "value 1e39 out of range for float32")
+}
+
+func TestIgnoreUnknownKeys(t *testing.T) {
+	type xconfig struct {
+		Name string
+		Sub  struct {
+			Port int
+		}
+	}
+	const src = `Name: x
+Removed:
+	Nested: 1
+Sub:
+	Port: 1
+	Gone: 2
+`
+	var warnings []*ParseError
+	opts := DecoderOptions{
+		IgnoreUnknownKeys: true,
+		Warn: func(w *ParseError) {
+			warnings = append(warnings, w)
+		},
+	}
+	var config xconfig
+	if err := NewDecoder(strings.NewReader(src), opts).Decode(&config); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if config.Name != "x" || config.Sub.Port != 1 {
+		t.Fatalf("got %#v, expected values", config)
+	}
+	if len(warnings) != 2 || warnings[0].Line != 2 || warnings[0].KeyPath != "Removed" || warnings[1].Line != 6 || warnings[1].Kind != KindUnknownKey {
+		t.Fatalf("got warnings %v, expected unknown keys at lines 2 and 6", warnings)
+	}
+
+	opts.Strict = true
+	err := NewDecoder(strings.NewReader(src), opts).Decode(&xconfig{})
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Kind != KindUnknownKey || perr.Line != 2 {
+		t.Fatalf("got %v, expected unknown key error in strict mode", err)
+	}
+}
@@ -0,0 +1,196 @@
+package sconf
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mjl-/sconf/ast"
+)
+
+// typeKey is the key in the block of an interface value that names its type.
+const typeKey = "Type"
+
+var registry = struct {
+	sync.RWMutex
+	types map[string]reflect.Type // By name.
+	names map[reflect.Type]string // By type.
+}{
+	types: map[string]reflect.Type{},
+	names: map[reflect.Type]string{},
+}
+
+// Register makes the type of value, a struct or pointer to struct, available
+// for fields of interface type under name. An interface value is written as a
+// block with a "Type" key naming the registered type, followed by the fields of
+// the struct:
+//
+//	Backends:
+//		-
+//			Type: s3
+//			Bucket: backups
+//		-
+//			Type: disk
+//			Dir: /var/backups
+//
+// A nil interface is written as the value "nil". Register panics if name or
+// the type is already registered, or if the struct has a field with key "Type".
+// It is typically called from an init function.
+func Register(name string, value interface{}) {
+	if name == "" || strings.TrimSpace(name) != name || strings.Contains(name, "\n") {
+		panic(fmt.Sprintf("sconf: invalid name %q for register", name))
+	}
+	t := reflect.TypeOf(value)
+	if t == nil {
+		panic("sconf: register of nil value")
+	}
+	st := t
+	if st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+	if st.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("sconf: register of %v, must be struct or pointer to struct", t))
+	}
+	fields, err := structFields(st)
+	if err != nil {
+		panic(fmt.Sprintf("sconf: register of %v: %v", t, err))
+	}
+	for _, f := range fields {
+		if f.key == typeKey {
+			panic(fmt.Sprintf("sconf: register of %v: field %s has reserved key %q", t, f.Name, typeKey))
+		}
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+	if o, ok := registry.types[name]; ok {
+		panic(fmt.Sprintf("sconf: register of %v: name %q already registered for %v", t, name, o))
+	}
+	if o, ok := registry.names[t]; ok {
+		panic(fmt.Sprintf("sconf: register of %v: type already registered as %q", t, o))
+	}
+	registry.types[name] = t
+	registry.names[t] = name
+}
+
+// registeredType returns the type registered under name.
+func registeredType(name string) (reflect.Type, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	t, ok := registry.types[name]
+	return t, ok
+}
+
+// registeredName returns the name of registered type t.
+func registeredName(t reflect.Type) (string, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	name, ok := registry.names[t]
+	return name, ok
+}
+
+// registeredNames returns the names of the registered types that implement
+// interface type t, for errors.
+func registeredNames(t reflect.Type) []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	var l []string
+	for name, rt := range registry.types {
+		if rt.Implements(t) {
+			l = append(l, name)
+		}
+	}
+	sort.Strings(l)
+	return l
+}
+
+// parseInterface parses an interface value, "nil" or a block with a Type key,
+// into v.
+func (p *parser) parseInterface(v reflect.Value) {
+	t := v.Type()
+	if p.line == "nil" {
+		p.consume()
+		v.Set(reflect.Zero(t))
+		return
+	}
+
+	n := p.node()
+	fail := func(n *Node, kind ErrorKind, format string, args ...interface{}) {
+		panic(parseError{n.error(kind, fmt.Errorf(format, args...))})
+	}
+	if n.Value != "" {
+		fail(n, KindValue, "unexpected value %q for %v, must be nil or nested lines with key %q", n.Value, t, typeKey)
+	}
+	var tn *Node
+	for _, c := range n.Children {
+		if c.Key != typeKey {
+			continue
+		}
+		if tn != nil {
+			fail(c, KindDuplicateKey, "duplicate key in struct")
+		}
+		tn = c
+	}
+	if tn == nil {
+		fail(n, KindMissingKey, "missing required key %q for %v", typeKey, t)
+	}
+	if len(tn.Children) > 0 {
+		fail(tn.Children[0], KindSyntax, "unexpected nested line for key %q", typeKey)
+	}
+	rt, ok := registeredType(tn.Value)
+	if !ok || !rt.Implements(t) {
+		names := registeredNames(t)
+		if len(names) == 0 {
+			fail(tn, KindValue, "unknown type %q, no types registered for %v", tn.Value, t)
+		}
+		fail(tn, KindValue, "unknown type %q for %v, must be one of %s", tn.Value, t, strings.Join(names, ", "))
+	}
+
+	// Parse the block as struct of the registered type, without the Type line. The
+	// struct may have no other lines, so we cannot use Decode which requires them.
+	var lines []*ast.Node
+	for _, l := range n.lines {
+		if l != tn.syntax {
+			lines = append(lines, l)
+		}
+	}
+	cv := reflect.New(rt)
+	np := n.parser(n.syntax, lines)
+	err := np.run(func() {
+		sv := cv.Elem()
+		if rt.Kind() == reflect.Ptr {
+			sv.Set(reflect.New(rt.Elem()))
+			sv = sv.Elem()
+		}
+		np.prefix += np.indentUnit
+		np.parseStruct0(sv, n.Line)
+	})
+	if err != nil {
+		panic(parseError{err.(*ParseError)})
+	}
+	v.Set(cv.Elem())
+}
+
+// describeInterface writes non-nil interface value v as block with the name of
+// its registered type.
+func (w *writer) describeInterface(v reflect.Value) {
+	t := v.Type()
+	name, ok := registeredName(t)
+	if !ok {
+		w.error(fmt.Errorf("type %v not registered", t))
+	}
+	if t.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v = reflect.Zero(t.Elem())
+		} else {
+			v = v.Elem()
+		}
+	}
+	w.write("\n")
+	w.indent()
+	w.write(w.prefix + typeKey + ": " + name + "\n")
+	w.describeStruct(v)
+	w.unindent()
+}
@@ -0,0 +1,110 @@
+package sconf
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type backend interface {
+	backend()
+}
+
+type s3Backend struct {
+	Bucket string
+	Region string `sconf:"optional"`
+}
+
+func (s3Backend) backend() {}
+
+type diskBackend struct {
+	Dir string
+}
+
+func (*diskBackend) backend() {}
+
+func init() {
+	Register("s3", s3Backend{})
+	Register("disk", &diskBackend{})
+}
+
+func TestRegister(t *testing.T) {
+	type xconfig struct {
+		Backends []backend
+		Default  backend `sconf:"optional"`
+	}
+
+	const src = `Backends:
+	-
+		Type: s3
+		Bucket: backups
+	-
+		Dir: /var/backups
+		Type: disk
+	- nil
+`
+	var config xconfig
+	if err := Parse(strings.NewReader(src), &config); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	exp := xconfig{Backends: []backend{s3Backend{Bucket: "backups"}, &diskBackend{"/var/backups"}, nil}}
+	if !reflect.DeepEqual(config, exp) {
+		t.Fatalf("got %#v, expected %#v", config, exp)
+	}
+
+	out := &bytes.Buffer{}
+	if err := Write(out, config); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	expOut := `Backends:
+	-
+		Type: s3
+		Bucket: backups
+	-
+		Type: disk
+		Dir: /var/backups
+	- nil
+`
+	if out.String() != expOut {
+		t.Fatalf("got:\n%s\n\nexpected:\n%s", out.String(), expOut)
+	}
+
+	test := func(old, new string, line int, keyPath string, kind ErrorKind, exp string) {
+		t.Helper()
+		err := Parse(strings.NewReader(strings.Replace(src, old, new, 1)), &xconfig{})
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Line != line || perr.KeyPath != keyPath || perr.Kind != kind || perr.Err.Error() != exp {
+			t.Fatalf("got %v (line %d, key %s), expected %s error %q at line %d, key %s", err, perr.Line, perr.KeyPath, kind, exp, line, keyPath)
+		}
+	}
+	test("Type: s3", "Type: ftp", 3, "Backends[0].Type", KindValue, `unknown type "ftp" for sconf.backend, must be one of disk, s3`)
+	test("\t\tType: s3\n", "", 2, "Backends[0]", KindMissingKey, `missing required key "Type" for sconf.backend`)
+	test("Bucket: backups", "Bucket: backups\n\t\tType: s3", 5, "Backends[0].Type", KindDuplicateKey, "duplicate key in struct")
+	test("Bucket: backups", "Bucket: backups\n\t\tBogus: 1", 5, "Backends[0].Bogus", KindUnknownKey, `unknown key "Bogus"`)
+	test("\t\tBucket: backups\n", "", 2, "Backends[0].Bucket", KindMissingKey, `missing required key "Bucket"`)
+	test("- nil", "- x", 8, "Backends[2]", KindValue, `unexpected value "x" for sconf.backend, must be nil or nested lines with key "Type"`)
+
+	type other struct{ X int }
+	var unregistered struct{ B interface{} }
+	unregistered.B = other{1}
+	if err := Write(&bytes.Buffer{}, unregistered); err == nil || !strings.Contains(err.Error(), "not registered") {
+		t.Fatalf("got %v, expected error for unregistered type", err)
+	}
+
+	testPanic := func(name string, v interface{}) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("register of %q: expected panic", name)
+			}
+		}()
+		Register(name, v)
+	}
+	testPanic("s3", other{})
+	testPanic("other", s3Backend{})
+	testPanic("other", 1)
+	testPanic("other", struct{ Type string }{})
+	testPanic("", other{})
+}
@@ -1,49 +1,152 @@
 package sconf
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"os"
 	"reflect"
+	"strings"
 )
 
-// ParseFile reads an sconf file from path into dst.
+// ParseFile reads an sconf file from path into dst, with include directives
+// resolved relative to the directory of path. Errors in the file are returned as
+// *ParseError.
 func ParseFile(path string, dst interface{}) error {
 	src, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 	defer src.Close()
-	return parse(path, src, dst)
+	return parse(src, dst, DecoderOptions{Path: path, Includes: true}, nil)
 }
 
-// Parse reads an sconf file from a reader into dst.
+// Parse reads an sconf file from a reader into dst. Include directives are not
+// allowed. Errors in the file are returned as *ParseError.
 func Parse(src io.Reader, dst interface{}) error {
-	return parse("", src, dst)
+	return parse(src, dst, DecoderOptions{}, nil)
+}
+
+// DecoderOptions configures a Decoder.
+type DecoderOptions struct {
+	// Path is the file name used in errors.
+	Path string
+
+	// AllErrors makes the decoder continue after an error in a key/value or list
+	// item, skipping the remainder of that item including its nested lines. All
+	// errors, including all missing required keys, are returned as ParseErrors.
+	AllErrors bool
+
+	// Warn is called for problems that do not prevent parsing, such as the use of a
+	// deprecated key. If nil, warnings are ignored.
+	Warn func(warning *ParseError)
+
+	// Strict makes warnings errors, e.g. for fields with a "deprecated" sconf tag.
+	Strict bool
+
+	// IgnoreUnknownKeys makes the decoder skip keys that are not present in the
+	// destination struct, including their nested lines, reporting them as warning
+	// instead of error.
+	IgnoreUnknownKeys bool
+
+	// Indent is one level of indenting, a tab if empty. It must consist of spaces
+	// or tabs.
+	Indent string
+
+	// Includes allows include directives, reading other files. Relative paths are
+	// resolved against the directory of Path. Only enable it for trusted input.
+	Includes bool
+}
+
+func (o DecoderOptions) indent() string {
+	if o.Indent == "" {
+		return "\t"
+	}
+	return o.Indent
+}
+
+// Decoder reads sconf files.
+type Decoder struct {
+	r    io.Reader
+	opts DecoderOptions
+}
+
+// NewDecoder returns a decoder that reads from r.
+func NewDecoder(r io.Reader, opts DecoderOptions) *Decoder {
+	return &Decoder{r, opts}
+}
+
+// Decode reads an sconf file into dst. Errors in the file are returned as
+// *ParseError, or as ParseErrors if AllErrors is set.
+func (d *Decoder) Decode(dst interface{}) error {
+	return parse(d.r, dst, d.opts, nil)
+}
+
+// EncoderOptions configures an Encoder.
+type EncoderOptions struct {
+	// Docs makes the encoder write comments, from "sconf-doc" struct tags and about
+	// optional fields, defaults and constraints.
+	Docs bool
+
+	// KeepZero makes the encoder write zero values of optional fields, and an
+	// example element for empty lists and maps. Deprecated fields are written like
+	// other fields, unlike with Describe.
+	KeepZero bool
+
+	// Indent is one level of indenting, a tab if empty. Files written with another
+	// indent must be read with the same Indent in DecoderOptions.
+	Indent string
+
+	// MaxWidth is the width at which comments are wrapped, 80 if 0. Comments are
+	// not wrapped if negative.
+	MaxWidth int
+
+	// FloatFormat is the fmt format for floats, "%f" if empty.
+	FloatFormat string
+}
+
+// Encoder writes sconf files.
+type Encoder struct {
+	w    io.Writer
+	opts EncoderOptions
+}
+
+// NewEncoder returns an encoder that writes to w.
+func NewEncoder(w io.Writer, opts EncoderOptions) *Encoder {
+	return &Encoder{w, opts}
+}
+
+// Encode writes v, a struct or pointer to struct, as sconf file. Encode does not
+// detect recursive values and will attempt to write them.
+func (e *Encoder) Encode(v interface{}) error {
+	return describe(e.w, v, e.opts, false)
 }
 
 // Describe writes an example sconf file describing v to w. The file includes all
-// fields, values and documentation on the fields as configured with the "sconf"
-// and "sconf-doc" struct tags. Describe does not detect recursive values and will
+// fields except deprecated fields, values and documentation on the fields as
+// configured with the "sconf" and "sconf-doc" struct tags. Describe does not detect recursive values and will
 // attempt to write them.
 func Describe(w io.Writer, v interface{}) error {
-	return describe(w, v, true, true)
+	return describe(w, v, EncoderOptions{Docs: true, KeepZero: true}, true)
 }
 
 // Write writes a valid sconf file describing v to w, without comments, without
 // zero values of optional fields. Write does not detect recursive values and
 // will attempt to write them.
 func Write(w io.Writer, v interface{}) error {
-	return describe(w, v, false, false)
+	return describe(w, v, EncoderOptions{}, false)
 }
 
 // WriteDocs is like Write, but does write comments.
 func WriteDocs(w io.Writer, v interface{}) error {
-	return describe(w, v, false, true)
+	return describe(w, v, EncoderOptions{Docs: true}, false)
 }
 
-func describe(w io.Writer, v interface{}, keepZero bool, docs bool) (err error) {
+// describe writes v with opts. If example is set, deprecated fields are left out,
+// for Describe.
+func describe(w io.Writer, v interface{}, opts EncoderOptions, example bool) error {
+	if err := checkIndent(opts.Indent); err != nil {
+		return err
+	}
 	value := reflect.ValueOf(v)
 	t := value.Type()
 	if t.Kind() == reflect.Ptr {
@@ -53,19 +156,19 @@ func describe(w io.Writer, v interface{}, keepZero bool, docs bool) (err error)
 	if t.Kind() != reflect.Struct {
 		return fmt.Errorf("top level object must be a struct, is a %T", v)
 	}
-	defer func() {
-		x := recover()
-		if x == nil {
-			return
-		}
-		if e, ok := x.(writeError); ok {
-			err = error(e)
-		} else {
-			panic(x)
-		}
-	}()
-	wr := &writer{out: bufio.NewWriter(w), keepZero: keepZero, docs: docs}
-	wr.describeStruct(value)
-	wr.flush()
+	wr := newWriter(w, opts)
+	wr.example = example
+	return wr.run(func() {
+		wr.describeStruct(value)
+		wr.flush()
+	})
+}
+
+// checkIndent returns an error if indent is not empty and has characters other
+// than spaces or tabs.
+func checkIndent(indent string) error {
+	if strings.Trim(indent, " \t") != "" {
+		return fmt.Errorf("indent %q must consist of spaces or tabs", indent)
+	}
 	return nil
 }
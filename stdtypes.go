@@ -0,0 +1,69 @@
+package sconf
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Standard library types without text marshaling that are parsed and written as
+// a single value. Most others, e.g. time.Time, net.IP, netip.Addr and
+// regexp.Regexp, implement encoding.TextMarshaler and encoding.TextUnmarshaler.
+var (
+	urlType      = reflect.TypeOf(url.URL{})
+	ipNetType    = reflect.TypeOf(net.IPNet{})
+	fileModeType = reflect.TypeOf(os.FileMode(0))
+)
+
+// isStdType returns whether values of t are parsed by parseStdType and written by
+// describeStdType.
+func isStdType(t reflect.Type) bool {
+	return t == urlType || t == ipNetType || t == fileModeType
+}
+
+// parseStdType parses a value of a type for which isStdType is true.
+func (p *parser) parseStdType(v reflect.Value) {
+	switch v.Type() {
+	case urlType:
+		u, err := url.Parse(p.parseString())
+		p.check(err, "parsing url")
+		v.Set(reflect.ValueOf(*u))
+
+	case ipNetType:
+		_, ipnet, err := net.ParseCIDR(p.parseString())
+		p.check(err, "parsing ip network")
+		v.Set(reflect.ValueOf(*ipnet))
+
+	case fileModeType:
+		// Always octal, with optional leading "0" or "0o".
+		s := p.consume()
+		x, err := strconv.ParseUint(strings.TrimPrefix(s, "0o"), 8, 32)
+		p.check(err, "parsing octal file mode")
+		v.SetUint(x)
+	}
+}
+
+// describeStdType writes a value of a type for which isStdType is true.
+func (w *writer) describeStdType(v reflect.Value) {
+	switch v.Type() {
+	case urlType:
+		u := v.Interface().(url.URL)
+		w.describeString(u.String())
+
+	case ipNetType:
+		ipnet := v.Interface().(net.IPNet)
+		if ipnet.IP == nil {
+			// String returns "<nil>", which would not parse.
+			w.describeString("")
+		} else {
+			w.describeString(ipnet.String())
+		}
+
+	case fileModeType:
+		w.write(fmt.Sprintf(" %#o\n", v.Uint()))
+	}
+}
@@ -0,0 +1,161 @@
+package sconf
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a number of bytes, written in config files with a unit, e.g.
+// "512MiB" or "1.5GB". Decimal units (KB, MB, GB, TB, PB, EB) are powers of 1000,
+// binary units (KiB, MiB, GiB, TiB, PiB, EiB) are powers of 1024. A value
+// without unit, or with unit "B", is in bytes.
+type ByteSize int64
+
+// Units of ByteSize.
+const (
+	Byte ByteSize = 1
+
+	KB ByteSize = 1000 * Byte
+	MB          = 1000 * KB
+	GB          = 1000 * MB
+	TB          = 1000 * GB
+	PB          = 1000 * TB
+	EB          = 1000 * PB
+
+	KiB ByteSize = 1024 * Byte
+	MiB          = 1024 * KiB
+	GiB          = 1024 * MiB
+	TiB          = 1024 * GiB
+	PiB          = 1024 * TiB
+	EiB          = 1024 * PiB
+)
+
+// byteUnits is ordered by size, largest first, binary before decimal.
+var byteUnits = []struct {
+	name string
+	size ByteSize
+}{
+	{"EiB", EiB},
+	{"EB", EB},
+	{"PiB", PiB},
+	{"PB", PB},
+	{"TiB", TiB},
+	{"TB", TB},
+	{"GiB", GiB},
+	{"GB", GB},
+	{"MiB", MiB},
+	{"MB", MB},
+	{"KiB", KiB},
+	{"KB", KB},
+	{"B", Byte},
+}
+
+var byteSizeType = reflect.TypeOf(ByteSize(0))
+var percentType = reflect.TypeOf(Percent(0))
+
+// ParseByteSize parses a size like "512MiB", "1.5GB" or "100": decimal digits,
+// optionally with a fraction, and an optional unit. A fraction is allowed if the
+// result is a whole number of bytes. Sizes cannot be negative.
+func ParseByteSize(s string) (ByteSize, error) {
+	num := strings.TrimRight(s, "BEPTGMKi")
+	unit := s[len(num):]
+	size := Byte
+	if unit != "" {
+		var ok bool
+		for _, u := range byteUnits {
+			if u.name == unit {
+				size = u.size
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return 0, fmt.Errorf("unknown unit %q in size %q", unit, s)
+		}
+	}
+	// Rat would also accept signs, fractions like "1/2", exponents, prefixes like
+	// 0x and underscores.
+	if !isDecimal(num) {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	r, ok := new(big.Rat).SetString(num)
+	if !ok {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	r.Mul(r, new(big.Rat).SetInt64(int64(size)))
+	if !r.IsInt() {
+		return 0, fmt.Errorf("size %q is not a whole number of bytes", s)
+	}
+	if !r.Num().IsInt64() {
+		return 0, fmt.Errorf("size %q out of range", s)
+	}
+	return ByteSize(r.Num().Int64()), nil
+}
+
+// isDecimal returns whether s is digits, optionally followed by a dot and more
+// digits.
+func isDecimal(s string) bool {
+	i := strings.IndexByte(s, '.')
+	if i >= 0 && !isDigits(s[i+1:]) {
+		return false
+	} else if i >= 0 {
+		s = s[:i]
+	}
+	return isDigits(s)
+}
+
+// isDigits returns whether s is one or more decimal digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the size with the largest unit that represents it exactly, e.g.
+// "512MiB" or "1500MB".
+func (b ByteSize) String() string {
+	if b == 0 {
+		return "0B"
+	}
+	for _, u := range byteUnits {
+		if b%u.size == 0 {
+			return strconv.FormatInt(int64(b/u.size), 10) + u.name
+		}
+	}
+	panic("unreachable")
+}
+
+// Percent is a percentage, written in config files with a percent sign, e.g.
+// "10%" or "12.5%". The value of 10% is 10.
+type Percent float64
+
+// ParsePercent parses a percentage like "10%".
+func ParsePercent(s string) (Percent, error) {
+	if !strings.HasSuffix(s, "%") {
+		return 0, fmt.Errorf("missing %% in percentage %q", s)
+	}
+	f, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad percentage %q", s)
+	}
+	return Percent(f), nil
+}
+
+// String returns the percentage with a percent sign.
+func (p Percent) String() string {
+	return strconv.FormatFloat(float64(p), 'g', -1, 64) + "%"
+}
+
+// Fraction returns the percentage as fraction, e.g. 0.1 for 10%.
+func (p Percent) Fraction() float64 {
+	return float64(p) / 100
+}
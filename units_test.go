@@ -0,0 +1,103 @@
+package sconf
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestByteSize(t *testing.T) {
+	test := func(s string, exp ByteSize, expStr string) {
+		t.Helper()
+		b, err := ParseByteSize(s)
+		if err != nil {
+			t.Fatalf("parse %q: %v", s, err)
+		}
+		if b != exp {
+			t.Fatalf("parse %q: got %d, expected %d", s, b, exp)
+		}
+		if b.String() != expStr {
+			t.Fatalf("string of %q: got %q, expected %q", s, b.String(), expStr)
+		}
+	}
+	test("0", 0, "0B")
+	test("100", 100, "100B")
+	test("100B", 100, "100B")
+	test("512MiB", 512*MiB, "512MiB")
+	test("1.5GB", 1500*MB, "1500MB")
+	test("0.5KiB", 512, "512B")
+	test("1024KiB", MiB, "1MiB")
+	test("2000KB", 2*MB, "2MB")
+	test("7EiB", 7*EiB, "7EiB")
+
+	testBad := func(s, exp string) {
+		t.Helper()
+		_, err := ParseByteSize(s)
+		if err == nil || err.Error() != exp {
+			t.Fatalf("parse %q: got %v, expected error %q", s, err, exp)
+		}
+	}
+	testBad("1.1B", `size "1.1B" is not a whole number of bytes`)
+	testBad("1mb", `bad size "1mb"`)
+	testBad("1XB", `bad size "1XB"`)
+	testBad("1iB", `unknown unit "iB" in size "1iB"`)
+	testBad("KiB", `bad size "KiB"`)
+	testBad("1e3B", `bad size "1e3B"`)
+	testBad("16EiB", `size "16EiB" out of range`)
+	testBad("-1KiB", `bad size "-1KiB"`)
+	testBad("+1KiB", `bad size "+1KiB"`)
+	testBad("0x10", `bad size "0x10"`)
+	testBad("0b1KiB", `bad size "0b1KiB"`)
+	testBad("0xAB", `bad size "0xAB"`)
+	testBad("1_000", `bad size "1_000"`)
+	testBad("1/2KiB", `bad size "1/2KiB"`)
+	testBad(".5KiB", `bad size ".5KiB"`)
+	testBad("1.KiB", `bad size "1.KiB"`)
+}
+
+func TestUnits(t *testing.T) {
+	type xconfig struct {
+		Memory ByteSize `sconf-validate:"min=1MiB"`
+		Quota  ByteSize `sconf:"optional" sconf-default:"1GB"`
+		Usage  Percent  `sconf-validate:"max=100%"`
+	}
+
+	const src = `Memory: 512MiB
+Usage: 12.5%
+`
+	var config xconfig
+	if err := Parse(strings.NewReader(src), &config); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if exp := (xconfig{512 * MiB, GB, 12.5}); config != exp {
+		t.Fatalf("got %#v, expected %#v", config, exp)
+	}
+	if f := config.Usage.Fraction(); f != 0.125 {
+		t.Fatalf("got fraction %v, expected 0.125", f)
+	}
+
+	out := &bytes.Buffer{}
+	if err := Write(out, config); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if exp := "Memory: 512MiB\nQuota: 1GB\nUsage: 12.5%\n"; out.String() != exp {
+		t.Fatalf("got %q, expected %q", out.String(), exp)
+	}
+	if err := Write(&bytes.Buffer{}, xconfig{Memory: -KiB}); err == nil || err.Error() != "cannot write negative size -1KiB" {
+		t.Fatalf("write negative size: got %v, expected error", err)
+	}
+
+	test := func(old, new string, kind ErrorKind, exp string) {
+		t.Helper()
+		err := Parse(strings.NewReader(strings.Replace(src, old, new, 1)), &xconfig{})
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Kind != kind || perr.Err.Error() != exp {
+			t.Fatalf("got %v, expected %s error %q", err, kind, exp)
+		}
+	}
+	test("512MiB", "1KiB", KindValidation, "value must be at least 1MiB")
+	test("512MiB", "1.5", KindValue, `parsing byte size: size "1.5" is not a whole number of bytes`)
+	test("12.5%", "12.5", KindValue, `parsing percentage: missing % in percentage "12.5"`)
+	test("12.5%", "120%", KindValidation, "value must be at most 100%")
+}
@@ -0,0 +1,293 @@
+package sconf
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/mjl-/sconf/ast"
+)
+
+// Update returns config file src with its values changed to those of v, a
+// struct or pointer to struct, keeping comments, empty lines and the order of
+// keys. Only lines of keys, list items and map entries with a changed value are
+// rewritten. Keys of v that are not in src are added after the last key of their
+// struct, keys that Write would leave out are removed, and lines for keys not in
+// v are kept as is. List items are matched by position, map entries by key.
+//
+// Files included by src are not read or changed. Keys are not added to a struct
+// that has an include directive, its keys may be in the included file.
+func Update(src []byte, v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := NewEncoder(&b, EncoderOptions{}).Update(src, v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Update writes config file src with its values changed to those of v. See the
+// package-level Update. Indent must be the indent of src. Docs adds comments
+// for added keys. KeepZero is ignored.
+func (e *Encoder) Update(src []byte, v interface{}) error {
+	if err := checkIndent(e.opts.Indent); err != nil {
+		return err
+	}
+	value := reflect.ValueOf(v)
+	t := value.Type()
+	if t.Kind() == reflect.Ptr {
+		value = value.Elem()
+		t = value.Type()
+	}
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("top level object must be a struct, is a %T", v)
+	}
+
+	f := ast.Parse(src, e.opts.Indent)
+	opts := e.opts
+	opts.KeepZero = false
+	u := &updater{
+		indent: f.Indent,
+		opts:   opts,
+		eol:    "\n",
+	}
+	u.w = newWriter(&u.buf, opts)
+	if l := f.Lines(); len(l) > 0 && l[0].EOL == "\r\n" {
+		u.eol = "\r\n"
+	}
+	err := u.w.run(func() {
+		f.Nodes = u.updateStruct(f.Nodes, value, "")
+	})
+	if err != nil {
+		return err
+	}
+	return ast.Fprint(e.w, f)
+}
+
+type updater struct {
+	w      *writer // Writes to buf, for rendering new lines.
+	buf    bytes.Buffer
+	indent string // One level of indenting.
+	opts   EncoderOptions
+	eol    string // Line ending for new lines, as of the first line of the file.
+}
+
+// render returns the nodes for the lines written by fn at indent prefix.
+func (u *updater) render(prefix string, fn func()) []*ast.Node {
+	u.buf.Reset()
+	oprefix := u.w.prefix
+	u.w.prefix = prefix
+	fn()
+	u.w.flush()
+	u.w.prefix = oprefix
+	f := ast.Parse(u.buf.Bytes(), u.indent)
+	for _, n := range f.Lines() {
+		n.Line = 0
+		n.EOL = u.eol
+	}
+	return f.Nodes
+}
+
+// insert adds nodes to l after its last node that is not empty or a comment,
+// keeping trailing comments at the end.
+func insert(l, nodes []*ast.Node) []*ast.Node {
+	i := len(l)
+	for i > 0 && (l[i-1].Kind == ast.Blank || l[i-1].Kind == ast.Comment) {
+		i--
+	}
+	r := append([]*ast.Node{}, l[:i]...)
+	r = append(r, nodes...)
+	return append(r, l[i:]...)
+}
+
+// updateStruct returns nodes, the lines of a struct at indent prefix, updated for
+// struct value v.
+func (u *updater) updateStruct(nodes []*ast.Node, v reflect.Value, prefix string) []*ast.Node {
+	fields, err := structFields(v.Type())
+	u.w.check(err)
+	byKey := map[string]field{}
+	for _, f := range fields {
+		byKey[f.key] = f
+		for _, a := range f.aliases {
+			byKey[a] = f
+		}
+	}
+
+	seen := map[string]bool{}
+	var include bool
+	var l []*ast.Node
+	for _, n := range nodes {
+		if n.Kind == ast.Include {
+			include = true
+		}
+		f, ok := byKey[n.Key]
+		if n.Kind != ast.KeyValue || !ok || seen[f.key] {
+			l = append(l, n)
+			continue
+		}
+		seen[f.key] = true
+		fv := fieldValue(v, f, false)
+		if u.w.omitField(f, fv) {
+			// An explicit zero value in the file is kept.
+			if u.equal(n, fv, false) {
+				l = append(l, n)
+			}
+			continue
+		}
+		key := n.Key
+		base := tagBase(f.Tag.Get("sconf"))
+		l = append(l, u.updateValue(n, fv, false, func(v reflect.Value) {
+			u.w.write(u.w.prefix + key + ":")
+			obase := u.w.base
+			u.w.base = base
+			u.w.describeValue(v)
+			u.w.base = obase
+		})...)
+	}
+	if include {
+		return l
+	}
+
+	var add []*ast.Node
+	for _, f := range fields {
+		if seen[f.key] {
+			continue
+		}
+		fv := fieldValue(v, f, false)
+		add = append(add, u.render(prefix, func() {
+			u.w.describeField(f, fv)
+		})...)
+	}
+	return insert(l, add)
+}
+
+// updateValue returns the nodes replacing n, a key/value or list item, for new
+// value v. If v is the value in the file, n is kept. Otherwise, nested lines are
+// updated for structs, maps and lists, and n is rewritten with write for other
+// values. For entry, n is a map entry, with "nil" for an empty value.
+func (u *updater) updateValue(n *ast.Node, v reflect.Value, entry bool, write func(v reflect.Value)) []*ast.Node {
+	if u.equal(n, v, entry) {
+		return []*ast.Node{n}
+	}
+
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	t := v.Type()
+	if n.Value == "" && len(n.Children) > 0 && !isText(t) && !isMarshaler(t) && !isStdType(t) {
+		prefix := n.Indent + u.indent
+		switch t.Kind() {
+		case reflect.Struct:
+			n.Children = u.updateStruct(n.Children, v, prefix)
+			return []*ast.Node{n}
+		case reflect.Map:
+			if v.Len() > 0 {
+				n.Children = u.updateMap(n.Children, v, prefix)
+				return []*ast.Node{n}
+			}
+		case reflect.Slice, reflect.Array:
+			if v.Len() > 0 && t.Elem().Kind() != reflect.Uint8 {
+				n.Children = u.updateList(n.Children, v, prefix)
+				return []*ast.Node{n}
+			}
+		}
+	}
+
+	l := u.render(n.Indent, func() { write(v) })
+	// Keep the original line if the value is written the same, e.g. for values that
+	// are not comparable.
+	if len(l) == 1 && len(l[0].Children) == 0 && len(n.Children) == 0 && l[0].Raw == n.Raw {
+		return []*ast.Node{n}
+	}
+	return l
+}
+
+// updateMap returns nodes, the entries of a map at indent prefix, updated for map
+// value v.
+func (u *updater) updateMap(nodes []*ast.Node, v reflect.Value, prefix string) []*ast.Node {
+	seen := map[interface{}]bool{}
+	var l []*ast.Node
+	for _, n := range nodes {
+		if n.Kind != ast.KeyValue {
+			l = append(l, n)
+			continue
+		}
+		k, err := parseMapKey(v.Type().Key(), n.Key)
+		if err != nil || seen[k.Interface()] {
+			l = append(l, n)
+			continue
+		}
+		seen[k.Interface()] = true
+		mv := v.MapIndex(k)
+		if !mv.IsValid() {
+			continue
+		}
+		key := n.Key
+		l = append(l, u.updateValue(n, mv, true, func(v reflect.Value) {
+			u.w.describeEntry(key, v)
+		})...)
+	}
+
+	var add []*ast.Node
+	for _, e := range u.w.mapKeys(v) {
+		if seen[e.k.Interface()] {
+			continue
+		}
+		mv := v.MapIndex(e.k)
+		add = append(add, u.render(prefix, func() {
+			u.w.describeEntry(e.s, mv)
+		})...)
+	}
+	return insert(l, add)
+}
+
+// updateList returns nodes, the items of a list at indent prefix, updated for
+// slice or array value v.
+func (u *updater) updateList(nodes []*ast.Node, v reflect.Value, prefix string) []*ast.Node {
+	write := func(v reflect.Value) {
+		u.w.write(u.w.prefix + "-")
+		u.w.describeValue(v)
+	}
+
+	var i int
+	var l []*ast.Node
+	for _, n := range nodes {
+		if n.Kind != ast.Item {
+			l = append(l, n)
+			continue
+		}
+		if i < v.Len() {
+			l = append(l, u.updateValue(n, v.Index(i), false, write)...)
+		}
+		i++
+	}
+
+	var add []*ast.Node
+	for ; i < v.Len(); i++ {
+		ev := v.Index(i)
+		add = append(add, u.render(prefix, func() { write(ev) })...)
+	}
+	return insert(l, add)
+}
+
+// equal returns whether the value of n, parsed as the type of v, is equal to v.
+// For entry, n is a map entry, with "nil" for the zero value.
+func (u *updater) equal(n *ast.Node, v reflect.Value, entry bool) bool {
+	if entry && n.Value == "nil" {
+		return len(n.Children) == 0 && v.IsZero()
+	}
+
+	p, ok := nodeParser(n, DecoderOptions{Indent: u.opts.Indent})
+	if !ok {
+		// We don't read included files.
+		return false
+	}
+	pv := reflect.New(v.Type()).Elem()
+	err := p.run(func() {
+		p.leave(n.Value)
+		pv = p.parseValue(pv)
+		if p.next() {
+			p.stop(KindSyntax, "unexpected line")
+		}
+	})
+	return err == nil && reflect.DeepEqual(pv.Interface(), v.Interface())
+}
@@ -0,0 +1,136 @@
+package sconf
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	type smtp struct {
+		Host string
+		Port int `sconf:"optional"`
+	}
+	type account struct {
+		Domain string
+		Quota  ByteSize `sconf:"optional"`
+	}
+	type xconfig struct {
+		Hostname string
+		Mode     int `sconf:"optional,octal"`
+		SMTP     smtp
+		Admins   []string
+		Accounts map[string]account
+		Debug    bool `sconf:"optional"`
+		Limit    int  `sconf:"optional"`
+	}
+
+	const src = `# Main config.
+
+Hostname: mail.example.org
+Unknown: kept
+SMTP:
+	# Relay.
+	Host: smtp1.example.org
+	Port: 25
+Admins:
+	# First admin.
+	- alice
+	- bob
+	- carol
+Accounts:
+	# Bob's account.
+	bob:
+		Domain: example.org
+		Quota: 1GiB
+	carol:
+		Domain: example.com
+Debug: true
+
+# End.
+`
+	var c xconfig
+	if err := NewDecoder(strings.NewReader(src), DecoderOptions{IgnoreUnknownKeys: true}).Decode(&c); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	test := func(c xconfig, exp string) {
+		t.Helper()
+		buf, err := Update([]byte(src), c)
+		if err != nil {
+			t.Fatalf("update: %v", err)
+		}
+		if string(buf) != exp {
+			t.Fatalf("got:\n%s\nexpected:\n%s", buf, exp)
+		}
+		var nc xconfig
+		if err := NewDecoder(bytes.NewReader(buf), DecoderOptions{IgnoreUnknownKeys: true}).Decode(&nc); err != nil {
+			t.Fatalf("parse updated: %v", err)
+		}
+		if !reflect.DeepEqual(nc, c) {
+			t.Fatalf("parsed updated file:\n%#v\nexpected:\n%#v", nc, c)
+		}
+	}
+
+	// Unchanged.
+	test(c, src)
+
+	c.SMTP.Host = "smtp2.example.org"
+	c.SMTP.Port = 0
+	c.Admins = []string{"alice", "dave"}
+	c.Accounts = map[string]account{
+		"bob":  {"example.org", 2 * GiB},
+		"dan":  {"example.net", 0},
+		"erin": {"example.com", 0},
+	}
+	c.Debug = false
+	c.Limit = 10
+	c.Mode = 0750
+	test(c, `# Main config.
+
+Hostname: mail.example.org
+Unknown: kept
+SMTP:
+	# Relay.
+	Host: smtp2.example.org
+Admins:
+	# First admin.
+	- alice
+	- dave
+Accounts:
+	# Bob's account.
+	bob:
+		Domain: example.org
+		Quota: 2GiB
+	dan:
+		Domain: example.net
+	erin:
+		Domain: example.com
+Mode: 0o750
+Limit: 10
+
+# End.
+`)
+}
+
+func TestUpdateCRLF(t *testing.T) {
+	type xconfig struct {
+		Name string
+		Port int
+	}
+	src := "# comment\r\nName: x\r\n"
+	buf, err := Update([]byte(src), xconfig{"y", 1})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if exp := "# comment\r\nName: y\r\nPort: 1\r\n"; string(buf) != exp {
+		t.Fatalf("got %q, expected %q", buf, exp)
+	}
+
+	var b bytes.Buffer
+	err = NewEncoder(&b, EncoderOptions{Indent: "x"}).Update([]byte(src), xconfig{})
+	if err == nil {
+		t.Fatalf("update with bad indent: expected error")
+	}
+}
@@ -0,0 +1,238 @@
+package sconf
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Parsed constraints by tag and type, so patterns are compiled only once.
+var constraintCache sync.Map // constraintKey -> []constraint
+
+type constraintKey struct {
+	t   reflect.Type
+	tag string
+}
+
+// constraint is a check from an "sconf-validate" struct tag.
+type constraint struct {
+	name string // min, max, oneof, pattern or nonempty.
+	arg  string
+
+	bound  reflect.Value // For min/max on numbers.
+	length int           // For min/max on strings, lists and maps.
+	oneof  []string
+	re     *regexp.Regexp
+}
+
+// fieldConstraints parses the "sconf-validate" tag of f. The tag holds
+// comma-separated constraints: "min=...", "max=...", "oneof=a|b|c", "nonempty"
+// and "pattern=...". A pattern runs to the end of the tag, so it can contain
+// commas. For numbers, min and max are bounds on the value, for strings, lists
+// and maps on the length.
+func fieldConstraints(f reflect.StructField) ([]constraint, error) {
+	tag := f.Tag.Get("sconf-validate")
+	if tag == "" {
+		return nil, nil
+	}
+	key := constraintKey{f.Type, tag}
+	if l, ok := constraintCache.Load(key); ok {
+		return l.([]constraint), nil
+	}
+	t := f.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var l []constraint
+	for tag != "" {
+		var s string
+		if strings.HasPrefix(tag, "pattern=") {
+			s, tag = tag, ""
+		} else if i := strings.Index(tag, ","); i >= 0 {
+			s, tag = tag[:i], tag[i+1:]
+		} else {
+			s, tag = tag, ""
+		}
+		c, err := parseConstraint(t, s)
+		if err != nil {
+			return nil, fmt.Errorf("sconf-validate tag of field %s: %v", f.Name, err)
+		}
+		l = append(l, c)
+	}
+	constraintCache.Store(key, l)
+	return l, nil
+}
+
+func parseConstraint(t reflect.Type, s string) (constraint, error) {
+	var c constraint
+	if s == "nonempty" {
+		c.name = s
+		switch t.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map:
+			return c, nil
+		}
+		return c, fmt.Errorf("nonempty not possible for type %v", t)
+	}
+
+	l := strings.SplitN(s, "=", 2)
+	if len(l) != 2 {
+		return c, fmt.Errorf("unknown constraint %q", s)
+	}
+	c.name = l[0]
+	c.arg = l[1]
+	switch c.name {
+	case "min", "max":
+		if isNumber(t) {
+			v, err := parseTagValue(t, c.arg)
+			if err != nil {
+				return c, fmt.Errorf("%s: %v", c.name, err)
+			}
+			c.bound = v
+			return c, nil
+		}
+		switch t.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+			n, err := strconv.Atoi(c.arg)
+			if err != nil {
+				return c, fmt.Errorf("%s: %v", c.name, err)
+			}
+			c.length = n
+			return c, nil
+		}
+	case "oneof":
+		if t.Kind() == reflect.String || isNumber(t) {
+			c.oneof = strings.Split(c.arg, "|")
+			return c, nil
+		}
+	case "pattern":
+		if t.Kind() == reflect.String {
+			re, err := regexp.Compile(c.arg)
+			if err != nil {
+				return c, fmt.Errorf("pattern: %v", err)
+			}
+			c.re = re
+			return c, nil
+		}
+	default:
+		return c, fmt.Errorf("unknown constraint %q", c.name)
+	}
+	return c, fmt.Errorf("%s not possible for type %v", c.name, t)
+}
+
+// whether t is a number that min/max constraints compare by value.
+func isNumber(t reflect.Type) bool {
+	if isText(t) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// String returns the constraint as mentioned in comments of Describe.
+func (c constraint) String() string {
+	if c.arg == "" {
+		return c.name
+	}
+	return c.name + " " + c.arg
+}
+
+// check returns an error if v does not satisfy the constraint. Nil pointers
+// are only checked by nonempty.
+func (c constraint) check(v reflect.Value) error {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if c.name == "nonempty" {
+				return fmt.Errorf("value must not be empty")
+			}
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch c.name {
+	case "nonempty":
+		if v.Len() == 0 {
+			return fmt.Errorf("value must not be empty")
+		}
+	case "min", "max":
+		var cmp int
+		what := "value"
+		if c.bound.IsValid() {
+			cmp = compareNumber(v, c.bound)
+		} else {
+			n := v.Len()
+			if v.Kind() == reflect.String {
+				n = utf8.RuneCountInString(v.String())
+			}
+			what = "length"
+			cmp = compareNumber(reflect.ValueOf(n), reflect.ValueOf(c.length))
+		}
+		if c.name == "min" && cmp < 0 {
+			return fmt.Errorf("%s must be at least %s", what, c.arg)
+		} else if c.name == "max" && cmp > 0 {
+			return fmt.Errorf("%s must be at most %s", what, c.arg)
+		}
+	case "oneof":
+		s := fmt.Sprint(v.Interface())
+		for _, x := range c.oneof {
+			if x == s {
+				return nil
+			}
+		}
+		return fmt.Errorf("value %q must be one of %s", s, c.arg)
+	case "pattern":
+		if !c.re.MatchString(v.String()) {
+			return fmt.Errorf("value %q must match pattern %s", v.String(), c.arg)
+		}
+	}
+	return nil
+}
+
+// compareNumber returns -1, 0 or 1 for a less than, equal to or greater than b,
+// both of the same kind.
+func compareNumber(a, b reflect.Value) int {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, y := a.Int(), b.Int()
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		x, y := a.Uint(), b.Uint()
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+	case reflect.Float32, reflect.Float64:
+		x, y := a.Float(), b.Float()
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+// validate returns an error for the first constraint that v does not satisfy.
+func validate(l []constraint, v reflect.Value) error {
+	for _, c := range l {
+		if err := c.check(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
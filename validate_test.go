@@ -0,0 +1,206 @@
+package sconf
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	type xconfig struct {
+		Port     int           `sconf-validate:"min=1,max=65535"`
+		Proto    string        `sconf-validate:"oneof=tcp|udp"`
+		Name     string        `sconf-validate:"min=2,pattern=^[a-z]{1,3}$"`
+		Hosts    []string      `sconf-validate:"nonempty"`
+		Owner    string        `sconf-validate:"nonempty"`
+		Timeout  time.Duration `sconf:"optional" sconf-validate:"max=1m" sconf-default:"10s"`
+		Ratio    *float64      `sconf:"optional" sconf-validate:"min=0,max=1"`
+		Listener map[string]struct {
+			Port uint16 `sconf-validate:"min=1024"`
+		} `sconf:"optional"`
+	}
+
+	const good = `Port: 25
+Proto: tcp
+Name: abc
+Hosts:
+	- localhost
+Owner: me
+Ratio: 0.5
+Listener:
+	x:
+		Port: 1024
+`
+	var config xconfig
+	if err := Parse(strings.NewReader(good), &config); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	test := func(old, new string, line, column int, keyPath, exp string) {
+		t.Helper()
+		src := strings.Replace(good, old, new, 1)
+		err := Parse(strings.NewReader(src), &xconfig{})
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("got %v, expected *ParseError", err)
+		}
+		if perr.Kind != KindValidation || perr.Line != line || perr.Column != column || perr.KeyPath != keyPath || perr.Err.Error() != exp {
+			t.Fatalf("got %q (%s) at %d:%d %s, expected %q at %d:%d %s", perr.Err, perr.Kind, perr.Line, perr.Column, perr.KeyPath, exp, line, column, keyPath)
+		}
+	}
+	test("Port: 25", "Port: 0", 1, 7, "Port", "value must be at least 1")
+	test("Port: 25", "Port: 65536", 1, 7, "Port", "value must be at most 65535")
+	test("Proto: tcp", "Proto: sctp", 2, 8, "Proto", `value "sctp" must be one of tcp|udp`)
+	test("Name: abc", "Name: a", 3, 7, "Name", "length must be at least 2")
+	test("Name: abc", "Name: abcd", 3, 7, "Name", `value "abcd" must match pattern ^[a-z]{1,3}$`)
+	test("Owner: me", "Owner: ", 6, 8, "Owner", "value must not be empty")
+	test("Ratio: 0.5", "Ratio: 1.5", 7, 8, "Ratio", "value must be at most 1")
+	test("\t\tPort: 1024", "\t\tPort: 80", 10, 9, "Listener.x.Port", "value must be at least 1024")
+
+	// All validation errors are reported.
+	src := strings.Replace(strings.Replace(good, "Port: 25", "Port: 0", 1), "Proto: tcp", "Proto: x", 1)
+	err := NewDecoder(strings.NewReader(src), DecoderOptions{AllErrors: true}).Decode(&xconfig{})
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("got %v, expected 2 errors", err)
+	}
+
+	out := &bytes.Buffer{}
+	if err := Describe(out, config); err != nil {
+		t.Fatalf("describe: %v", err)
+	}
+	exp := `# (min 1, max 65535)
+Port: 25
+
+# (oneof tcp|udp)
+Proto: tcp
+
+# (min 2, pattern ^[a-z]{1,3}$)
+Name: abc
+
+# (nonempty)
+Hosts:
+	- localhost
+
+# (nonempty)
+Owner: me
+
+# (optional, default 10s, max 1m)
+Timeout: 10s
+
+# (optional, min 0, max 1)
+Ratio: 0.500000
+
+# (optional)
+Listener:
+	x:
+
+		# (min 1024)
+		Port: 1024
+`
+	if out.String() != exp {
+		t.Fatalf("got:\n%s\n\nexpected:\n%s", out.String(), exp)
+	}
+
+	testTag := func(v interface{}, exp string) {
+		t.Helper()
+		err := Parse(strings.NewReader("X: 1\n"), v)
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Kind != KindTag || perr.Err.Error() != exp {
+			t.Fatalf("got %v, expected tag error %q", err, exp)
+		}
+	}
+	testTag(&struct {
+		X int `sconf-validate:"min=x"`
+	}{}, `sconf-validate tag of field X: min: parsing integer: strconv.ParseInt: parsing "x": invalid syntax`)
+	testTag(&struct {
+		X int `sconf-validate:"pattern=a"`
+	}{}, "sconf-validate tag of field X: pattern not possible for type int")
+	testTag(&struct {
+		X int `sconf-validate:"bogus"`
+	}{}, `sconf-validate tag of field X: unknown constraint "bogus"`)
+	testTag(&struct {
+		X int
+		Y int `sconf:"optional" sconf-default:"5" sconf-validate:"max=3"`
+	}{}, "sconf-default tag of field Y: value must be at most 3")
+}
+
+type tlsConfig struct {
+	Enabled  bool
+	CertFile string `sconf:"optional"`
+}
+
+func (c tlsConfig) Validate() error {
+	if c.Enabled && c.CertFile == "" {
+		return errors.New("CertFile required when TLS is enabled")
+	}
+	return nil
+}
+
+type listenerConfig struct {
+	Port int
+	TLS  tlsConfig
+}
+
+func (c *listenerConfig) Validate() error {
+	if c.Port == 443 && !c.TLS.Enabled {
+		return errors.New("TLS required for port 443")
+	}
+	return nil
+}
+
+func TestValidator(t *testing.T) {
+	type xconfig struct {
+		Listeners []listenerConfig
+	}
+
+	test := func(src string, line, endLine int, keyPath, exp string) {
+		t.Helper()
+		err := Parse(strings.NewReader(src), &xconfig{})
+		if exp == "" {
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			return
+		}
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("got %v, expected *ParseError", err)
+		}
+		if perr.Kind != KindValidation || perr.Line != line || perr.EndLine != endLine || perr.KeyPath != keyPath || perr.Err.Error() != exp {
+			t.Fatalf("got %q (%s) at %d-%d %s, expected %q at %d-%d %s", perr.Err, perr.Kind, perr.Line, perr.EndLine, perr.KeyPath, exp, line, endLine, keyPath)
+		}
+	}
+
+	const good = `Listeners:
+	-
+		Port: 443
+		TLS:
+			Enabled: true
+			CertFile: cert.pem
+	-
+		Port: 80
+		TLS:
+			Enabled: false
+`
+	test(good, 0, 0, "", "")
+	test(strings.Replace(good, "\t\t\tCertFile: cert.pem\n", "", 1), 4, 5, "Listeners[0].TLS", "CertFile required when TLS is enabled")
+	test(strings.Replace(good, "Port: 80", "Port: 443", 1), 7, 10, "Listeners[1]", "TLS required for port 443")
+
+	// Range of lines is included in the error message.
+	err := Parse(strings.NewReader(strings.Replace(good, "Port: 80", "Port: 443", 1)), &xconfig{})
+	if err == nil || !strings.HasPrefix(err.Error(), ":7-10: ") {
+		t.Fatalf("got %v, expected error for lines 7-10", err)
+	}
+
+	// Structs with errors in their fields are not validated.
+	src := strings.Replace(good, "\t\t\tCertFile: cert.pem\n", "", 1)
+	src = strings.Replace(src, "Port: 443", "Port: x", 1)
+	err = NewDecoder(strings.NewReader(src), DecoderOptions{AllErrors: true}).Decode(&xconfig{})
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 2 || errs[0].Kind != KindValue || errs[1].Kind != KindValidation {
+		t.Fatalf("got %v, expected value and validation error", err)
+	}
+}
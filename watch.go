@@ -0,0 +1,151 @@
+package sconf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WatchOptions configures a Watcher for config type T.
+type WatchOptions[T any] struct {
+	// Interval between checks of the file for changes, 1 second if 0.
+	Interval time.Duration
+
+	// DecoderOptions for parsing the file. Path is set to the watched file, and
+	// includes are allowed as with ParseFile.
+	DecoderOptions DecoderOptions
+
+	// Validate is called with a newly parsed config, after the checks of struct
+	// tags and Validate methods. If it returns an error, the config is not used.
+	Validate func(config *T) error
+
+	// Changed is called with a new config after it is published.
+	Changed func(config *T)
+
+	// Error is called when reading, parsing or validating a changed file fails.
+	// The previous config stays in use.
+	Error func(err error)
+}
+
+// Watcher keeps a config of type T, a struct, parsed from a file up to date. It
+// reads the file at each poll, and parses it again when the hash of its contents
+// changed, also for changes that keep the modification time and size. A new
+// config is published atomically, readers get it from Load. If a changed file
+// cannot be parsed, the previous config stays in use. Changes to included files
+// are not noticed.
+type Watcher[T any] struct {
+	path  string
+	opts  WatchOptions[T]
+	value atomic.Pointer[T]
+
+	mu   sync.Mutex // For checks.
+	hash [sha256.Size]byte
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Watch parses the config file at path into dst, and returns a Watcher that
+// checks the file for changes until Close is called. An error is returned if the
+// file cannot be parsed.
+func Watch[T any](path string, dst *T, opts WatchOptions[T]) (*Watcher[T], error) {
+	if t := reflect.TypeOf(dst).Elem(); t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("destination must be a pointer to a struct, is a %T", dst)
+	}
+	if opts.Interval == 0 {
+		opts.Interval = time.Second
+	}
+	w := &Watcher[T]{
+		path: path,
+		opts: opts,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if _, err := w.check(dst); err != nil {
+		return nil, err
+	}
+	go w.watch()
+	return w, nil
+}
+
+// Load returns the current config. The returned config must not be modified, it
+// is shared with other readers.
+func (w *Watcher[T]) Load() *T {
+	return w.value.Load()
+}
+
+// Check checks the file for changes now, e.g. after a SIGHUP, instead of waiting
+// for the next poll. It returns whether a new config was published. Changed and
+// Error are not called.
+func (w *Watcher[T]) Check() (bool, error) {
+	return w.check(nil)
+}
+
+// Close stops checking the file for changes. The last config remains available
+// through Load.
+func (w *Watcher[T]) Close() {
+	w.closeOnce.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+}
+
+func (w *Watcher[T]) watch() {
+	defer close(w.done)
+	t := time.NewTicker(w.opts.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-t.C:
+		}
+		changed, err := w.check(nil)
+		if err != nil && w.opts.Error != nil {
+			w.opts.Error(err)
+		} else if changed && w.opts.Changed != nil {
+			w.opts.Changed(w.Load())
+		}
+	}
+}
+
+// check parses the file if it changed, into dst if not nil, and publishes the
+// new config.
+func (w *Watcher[T]) check(dst *T) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	buf, err := os.ReadFile(w.path)
+	if err != nil {
+		return false, err
+	}
+	// A file that fails to parse is not parsed again until it changes.
+	hash := sha256.Sum256(buf)
+	if w.Load() != nil && hash == w.hash {
+		return false, nil
+	}
+	w.hash = hash
+
+	if dst == nil {
+		dst = new(T)
+	}
+	opts := w.opts.DecoderOptions
+	opts.Path = w.path
+	opts.Includes = true
+	if err := parse(bytes.NewReader(buf), dst, opts, nil); err != nil {
+		return false, err
+	}
+	if w.opts.Validate != nil {
+		if err := w.opts.Validate(dst); err != nil {
+			return false, err
+		}
+	}
+	w.value.Store(dst)
+	return true, nil
+}
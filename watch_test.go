@@ -0,0 +1,115 @@
+package sconf
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	type xconfig struct {
+		Name string
+		Port int `sconf-validate:"min=1"`
+	}
+	path := filepath.Join(t.TempDir(), "x.conf")
+	write := func(s string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	write("Name: a\nPort: 1\n")
+	changed := make(chan *xconfig, 1)
+	errs := make(chan error, 1)
+	var c xconfig
+	w, err := Watch(path, &c, WatchOptions[xconfig]{
+		Interval: 10 * time.Millisecond,
+		Validate: func(config *xconfig) error {
+			if config.Name == "invalid" {
+				return errors.New("invalid name")
+			}
+			return nil
+		},
+		Changed: func(config *xconfig) { changed <- config },
+		Error:   func(err error) { errs <- err },
+	})
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	defer w.Close()
+	if x := w.Load(); x != &c || c.Name != "a" {
+		t.Fatalf("got %#v, expected parsed config", x)
+	}
+
+	// The watcher publishes a new config.
+	write("Name: bb\nPort: 2\n")
+	select {
+	case x := <-changed:
+		if x.Name != "bb" || w.Load() != x || c.Name != "a" {
+			t.Fatalf("got %#v, expected new config", x)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no change noticed")
+	}
+
+	// A bad file is reported, keeping the config.
+	write("Name: ccc\nPort: 0\n")
+	select {
+	case err := <-errs:
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Kind != KindValidation || perr.Path != path {
+			t.Fatalf("got error %v, expected validation error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no error noticed")
+	}
+	if x := w.Load(); x.Name != "bb" {
+		t.Fatalf("got %#v after error, expected previous config", x)
+	}
+	w.Close()
+
+	// Checks without polling.
+	if ok, err := w.Check(); ok || err != nil {
+		t.Fatalf("check unchanged: got %v %v, expected no change", ok, err)
+	}
+	write("Name: invalid\nPort: 1\n")
+	if ok, err := w.Check(); ok || err == nil || err.Error() != "invalid name" {
+		t.Fatalf("check invalid: got %v %v, expected error", ok, err)
+	}
+	// Touching a file without changing it does not parse again.
+	write("Name: invalid\nPort: 1\n")
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	if ok, err := w.Check(); ok || err != nil {
+		t.Fatalf("check touched: got %v %v, expected no change", ok, err)
+	}
+	write("Name: dddd\nPort: 4\n")
+	if ok, err := w.Check(); !ok || err != nil || w.Load().Name != "dddd" {
+		t.Fatalf("check changed: got %v %v, expected new config", ok, err)
+	}
+	// A change with the same size and modification time is noticed.
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	write("Name: eeee\nPort: 5\n")
+	if err := os.Chtimes(path, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	if ok, err := w.Check(); !ok || err != nil || w.Load().Name != "eeee" {
+		t.Fatalf("check changed with same size and time: got %v %v, expected new config", ok, err)
+	}
+
+	var n int
+	if _, err := Watch(path, &n, WatchOptions[int]{}); err == nil {
+		t.Fatalf("watch with non-struct: expected error")
+	}
+	write("Name: x\n")
+	if _, err := Watch(path, &c, WatchOptions[xconfig]{}); err == nil {
+		t.Fatalf("watch bad file: expected error")
+	}
+}